@@ -0,0 +1,213 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// 认证成功的密钥的来源, 见 SSHMetadata.AuthKeySource
+const (
+	authKeyFromAgent = "agent"
+	authKeyFromFile  = "key#"
+)
+
+// authRecorder 记录最后一次尝试的认证方法, 握手成功时它就是成功的方法.
+// ssh 包会在同一个 goroutine 中依次尝试各个方法, 所以不用加锁
+type authRecorder struct {
+	method      string
+	keySource   string
+	fingerprint string
+	certKeyID   string
+}
+
+func (r *authRecorder) attempt(method string) {
+	r.method, r.keySource, r.fingerprint, r.certKeyID = method, "", "", ""
+}
+
+func (r *authRecorder) signed(source string, key ssh.PublicKey) {
+	r.method, r.keySource, r.certKeyID = "publickey", source, ""
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key, r.certKeyID = cert.Key, cert.KeyId
+	}
+	r.fingerprint = ssh.FingerprintSHA256(key)
+}
+
+func (r *authRecorder) apply(meta *SSHMetadata) {
+	meta.AuthMethod = r.method
+	if meta.AuthMethod == "" {
+		meta.AuthMethod = "none"
+	}
+	meta.AuthKeySource = r.keySource
+	meta.AuthKeyFingerprint = r.fingerprint
+	meta.AuthCertKeyID = r.certKeyID
+}
+
+// recordSigner 包装 signer, 在签名时记录使用的密钥. 服务端接受了公钥后才会签名,
+// 所以签名的密钥就是认证成功的密钥. 包装后仍然保持 AlgorithmSigner 等接口,
+// 否则 rsa 密钥只能用 ssh-rsa(sha1) 签名
+func recordSigner(signer ssh.Signer, source string, r *authRecorder) ssh.Signer {
+	s := &authSigner{Signer: signer, used: func() { r.signed(source, signer.PublicKey()) }}
+	as, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return s
+	}
+	if ms, ok := signer.(ssh.MultiAlgorithmSigner); ok {
+		return &authMultiAlgorithmSigner{authAlgorithmSigner: authAlgorithmSigner{authSigner: s, as: as}, algorithms: ms.Algorithms()}
+	}
+	return &authAlgorithmSigner{authSigner: s, as: as}
+}
+
+type authSigner struct {
+	ssh.Signer
+	used func()
+}
+
+func (s *authSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.used()
+	return s.Signer.Sign(rand, data)
+}
+
+type authAlgorithmSigner struct {
+	*authSigner
+	as ssh.AlgorithmSigner
+}
+
+func (s *authAlgorithmSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.used()
+	return s.as.SignWithAlgorithm(rand, data, algorithm)
+}
+
+type authMultiAlgorithmSigner struct {
+	authAlgorithmSigner
+	algorithms []string
+}
+
+func (s *authMultiAlgorithmSigner) Algorithms() []string {
+	return s.algorithms
+}
+
+// parsePrivateKey 解析 PEM 格式的私钥, 只有私钥是加密的时候才使用 passphrase
+func parsePrivateKey(pemBytes []byte, passphrase string) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err == nil {
+		return signer, nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) || passphrase == "" {
+		return nil, errors.Wrap(err, "unable to parse private key")
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse private key")
+	}
+	return signer, nil
+}
+
+// authKey 为一个候选的密钥和它的来源
+type authKey struct {
+	signer ssh.Signer
+	source string
+}
+
+// privateKeySigners 按顺序解析所有的私钥
+func privateKeySigners(keys []string, passphrase string) ([]authKey, error) {
+	var signers []authKey
+	for idx, key := range keys {
+		signer, err := parsePrivateKey([]byte(key), passphrase)
+		if err != nil {
+			if len(keys) > 1 {
+				return nil, errors.Wrap(err, authKeyFromFile+strconv.Itoa(idx+1))
+			}
+			return nil, err
+		}
+		signers = append(signers, authKey{signer: signer, source: authKeyFromFile + strconv.Itoa(idx+1)})
+	}
+	return signers, nil
+}
+
+// agentSigners 连接 ssh-agent 并取出它的所有密钥, socket 为空时使用环境变量 SSH_AUTH_SOCK,
+// 签名时还要用到这个连接, 所以握手完成后才能关闭返回的连接
+func agentSigners(socket string) (io.Closer, []authKey, error) {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return nil, nil, errors.New("ssh-agent is unavailable, SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "connect to ssh-agent")
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, errors.Wrap(err, "list keys of ssh-agent")
+	}
+	keys := make([]authKey, 0, len(signers))
+	for _, signer := range signers {
+		keys = append(keys, authKey{signer: signer, source: authKeyFromAgent})
+	}
+	return conn, keys, nil
+}
+
+// parseCertificate 解析 OpenSSH 的证书, 格式和 id_ed25519-cert.pub 文件相同
+func parseCertificate(text string) (*ssh.Certificate, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse certificate")
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("'" + pub.Type() + "' is not a certificate")
+	}
+	return cert, nil
+}
+
+// withCertificates 为和证书的公钥相同的密钥加上证书, 证书排在这个密钥的前面.
+// 没有对应的密钥的证书返回错误
+func withCertificates(keys []authKey, certs []string) ([]authKey, error) {
+	if len(certs) == 0 {
+		return keys, nil
+	}
+	parsed := make([]*ssh.Certificate, 0, len(certs))
+	for _, text := range certs {
+		cert, err := parseCertificate(text)
+		if err != nil {
+			return nil, err
+		}
+		if cert.CertType != ssh.UserCert {
+			return nil, errors.New("certificate '" + cert.KeyId + "' is not a user certificate")
+		}
+		parsed = append(parsed, cert)
+	}
+
+	used := make([]bool, len(parsed))
+	results := make([]authKey, 0, len(keys)+len(parsed))
+	for _, key := range keys {
+		for idx, cert := range parsed {
+			if !bytes.Equal(cert.Key.Marshal(), key.signer.PublicKey().Marshal()) {
+				continue
+			}
+			signer, err := ssh.NewCertSigner(cert, key.signer)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, authKey{signer: signer, source: key.source})
+			used[idx] = true
+		}
+		results = append(results, key)
+	}
+	for idx, ok := range used {
+		if !ok {
+			return nil, errors.New("private key of certificate '" + parsed[idx].KeyId + "' is missing")
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,141 @@
+package shell
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newPrivateKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(block)), publicKey
+}
+
+func authMetadata(t *testing.T, host, password, privateKey string, opts *SSHOptions) *SSHMetadata {
+	t.Helper()
+	conn, err := ConnectSSHWithOptions(host, "abc", password, privateKey, opts, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.Metadata().SSH
+}
+
+func TestSSHAuthPrivateKeys(t *testing.T) {
+	key1, _ := newPrivateKey(t, "")
+	key2, pub2 := newPrivateKey(t, "secret")
+	listener, host := startSSHSim(t, simConfig{PublicKeys: []ssh.PublicKey{pub2}})
+	defer listener.Close()
+
+	meta := authMetadata(t, host, "123", "", nil)
+	if meta.AuthMethod != "password" || meta.AuthKeySource != "" || meta.AuthKeyFingerprint != "" {
+		t.Errorf("want password got %#v", meta)
+	}
+
+	// 密码错误时使用第二个私钥, 它的密码和登录密码不同
+	meta = authMetadata(t, host, "wrong", key1, &SSHOptions{PrivateKeys: []string{key2}, Passphrase: "secret"})
+	if meta.AuthMethod != "publickey" || meta.AuthKeySource != "key#2" || meta.AuthKeyFingerprint != ssh.FingerprintSHA256(pub2) {
+		t.Errorf("want key#2 got %#v", meta)
+	}
+
+	// 没有 Passphrase 时不用登录密码解密, 即使登录密码就是私钥的密码
+	key4, pub4 := newPrivateKey(t, "wrong")
+	listener4, host4 := startSSHSim(t, simConfig{PublicKeys: []ssh.PublicKey{pub4}})
+	defer listener4.Close()
+	_, err := ConnectSSHWithOptions(host4, "abc", "wrong", "", &SSHOptions{PrivateKeys: []string{key4}}, nil, nil)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		t.Fatalf("want passphrase missing got %v", err)
+	}
+	meta = authMetadata(t, host4, "wrong", "", &SSHOptions{PrivateKeys: []string{key4}, PasswordAsPassphrase: true})
+	if meta.AuthMethod != "publickey" || meta.AuthKeySource != "key#1" {
+		t.Errorf("want key#1 got %#v", meta)
+	}
+
+	// 私钥没有加密时忽略密码
+	_, pub1 := newPrivateKey(t, "")
+	key3, pub3 := newPrivateKey(t, "")
+	listener3, host3 := startSSHSim(t, simConfig{PublicKeys: []ssh.PublicKey{pub1, pub3}})
+	defer listener3.Close()
+	meta = authMetadata(t, host3, "wrong", key3, nil)
+	if meta.AuthMethod != "publickey" || meta.AuthKeySource != "key#1" || meta.AuthKeyFingerprint != ssh.FingerprintSHA256(pub3) {
+		t.Errorf("want key#1 got %#v", meta)
+	}
+}
+
+func TestSSHAuthAgent(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: rsaKey}); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	listener, host := startSSHSim(t, simConfig{PublicKeys: []ssh.PublicKey{pub}})
+	defer listener.Close()
+
+	key, _ := newPrivateKey(t, "")
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	meta := authMetadata(t, host, "wrong", key, &SSHOptions{UseAgent: true})
+	if meta.AuthMethod != "publickey" || meta.AuthKeySource != "agent" || meta.AuthKeyFingerprint != ssh.FingerprintSHA256(pub) {
+		t.Errorf("want agent got %#v", meta)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := ConnectSSHWithOptions(host, "abc", "123", "", &SSHOptions{UseAgent: true}, nil, nil); err == nil {
+		t.Error("want error")
+	}
+	meta = authMetadata(t, host, "wrong", "", &SSHOptions{UseAgent: true, AgentSocket: socket})
+	if meta.AuthKeySource != "agent" {
+		t.Errorf("want agent got %#v", meta)
+	}
+}
@@ -0,0 +1,149 @@
+package shell
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// automaton 是由多个分隔符构造的 Aho–Corasick 自动机, 每收到一个字节只需要查一次表,
+// 与分隔符的个数和长度无关.
+//
+// 为了减少转移表的大小, 只出现在分隔符中的字节才各自占一个字符类, 其它的字节都属于字符类 0
+type automaton struct {
+	classes    [256]int32
+	numClasses int32
+
+	// next[state*numClasses+class] 为状态转移表, 状态 0 为初始状态
+	next []int32
+
+	// outputs[state] 为在该状态时匹配成功的分隔符序号, 已按序号从小到大排序
+	outputs [][]int
+}
+
+func newAutomaton(delims [][]byte) *automaton {
+	m := &automaton{numClasses: 1}
+	for _, delim := range delims {
+		for _, b := range delim {
+			if m.classes[b] == 0 {
+				m.classes[b] = m.numClasses
+				m.numClasses++
+			}
+		}
+	}
+
+	// 先构造 trie, -1 表示没有转移
+	trans := make([]int32, m.numClasses)
+	for i := range trans {
+		trans[i] = -1
+	}
+	outputs := [][]int{nil}
+	for idx, delim := range delims {
+		state := int32(0)
+		for _, b := range delim {
+			pos := state*m.numClasses + m.classes[b]
+			if trans[pos] < 0 {
+				trans[pos] = int32(len(outputs))
+				outputs = append(outputs, nil)
+				for i := int32(0); i < m.numClasses; i++ {
+					trans = append(trans, -1)
+				}
+			}
+			state = trans[pos]
+		}
+		outputs[state] = append(outputs[state], idx)
+	}
+
+	// 按广度优先计算失败转移, 同时将 trie 补全为确定的状态转移表
+	fail := make([]int32, len(outputs))
+	queue := make([]int32, 0, len(outputs))
+	for c := int32(0); c < m.numClasses; c++ {
+		if s := trans[c]; s < 0 {
+			trans[c] = 0
+		} else {
+			fail[s] = 0
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		outputs[state] = mergeOutputs(outputs[state], outputs[fail[state]])
+		for c := int32(0); c < m.numClasses; c++ {
+			pos := state*m.numClasses + c
+			if s := trans[pos]; s < 0 {
+				trans[pos] = trans[fail[state]*m.numClasses+c]
+			} else {
+				fail[s] = trans[fail[state]*m.numClasses+c]
+				queue = append(queue, s)
+			}
+		}
+	}
+
+	m.next = trans
+	m.outputs = outputs
+	return m
+}
+
+func mergeOutputs(a, b []int) []int {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+	merged := make([]int, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] <= b[0] {
+			merged = append(merged, a[0])
+			a = a[1:]
+		} else {
+			merged = append(merged, b[0])
+			b = b[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
+
+// step 返回收到 b 后的新状态
+func (m *automaton) step(state int32, b byte) int32 {
+	return m.next[state*m.numClasses+m.classes[b]]
+}
+
+// maxCachedAutomatons 为缓存的自动机的最大个数, 超过时清空缓存
+const maxCachedAutomatons = 256
+
+var automatons = struct {
+	sync.Mutex
+	cache map[string]*automaton
+}{cache: map[string]*automaton{}}
+
+// getAutomaton 返回 delims 对应的自动机, 同一组分隔符(通常为同一组 Matcher)只会构造一次
+func getAutomaton(delims [][]byte) *automaton {
+	var sb strings.Builder
+	for _, delim := range delims {
+		sb.WriteString(strconv.Itoa(len(delim)))
+		sb.WriteByte(':')
+		sb.Write(delim)
+	}
+	key := sb.String()
+
+	automatons.Lock()
+	m := automatons.cache[key]
+	automatons.Unlock()
+	if m != nil {
+		return m
+	}
+
+	m = newAutomaton(delims)
+
+	automatons.Lock()
+	if len(automatons.cache) >= maxCachedAutomatons {
+		automatons.cache = map[string]*automaton{}
+	}
+	automatons.cache[key] = m
+	automatons.Unlock()
+	return m
+}
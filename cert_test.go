@@ -0,0 +1,136 @@
+package shell
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim/sshd"
+	"golang.org/x/crypto/ssh"
+)
+
+func signCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, certType uint32, keyID string, principals ...string) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          1,
+		CertType:        certType,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if certType == ssh.UserCert {
+		cert.Permissions.Extensions = map[string]string{"permit-pty": ""}
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSSHUserCertificate(t *testing.T) {
+	ca := newHostKey(t)
+	key, pub := newPrivateKey(t, "")
+	cert := string(ssh.MarshalAuthorizedKey(signCert(t, ca, pub, ssh.UserCert, "abc-cert", "abc")))
+
+	options := &sshd.Options{}
+	options.AddUserCA(ca.PublicKey().Marshal())
+	options.WithNoEnable("ABC>", sshd.Echo)
+	listener, err := sshd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	host := "127.0.0.1:" + listener.Port()
+
+	meta := authMetadata(t, host, "", key, &SSHOptions{Certificates: []string{cert}})
+	if meta.AuthMethod != "publickey" || meta.AuthKeySource != "key#1" ||
+		meta.AuthCertKeyID != "abc-cert" || meta.AuthKeyFingerprint != ssh.FingerprintSHA256(pub) {
+		t.Errorf("want certificate got %#v", meta)
+	}
+
+	// 只有私钥不能登录
+	if _, err := ConnectSSHWithOptions(host, "abc", "", key, nil, nil, nil); err == nil {
+		t.Error("want error")
+	}
+
+	// principals 中没有用户名
+	other := string(ssh.MarshalAuthorizedKey(signCert(t, ca, pub, ssh.UserCert, "other", "other")))
+	if _, err := ConnectSSHWithOptions(host, "abc", "", key, &SSHOptions{Certificates: []string{other}}, nil, nil); err == nil {
+		t.Error("want error")
+	}
+
+	// 证书没有对应的私钥
+	key2, _ := newPrivateKey(t, "")
+	_, err = ConnectSSHWithOptions(host, "abc", "", key2, &SSHOptions{Certificates: []string{cert}}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "abc-cert") {
+		t.Errorf("want missing private key got %v", err)
+	}
+
+	// 主机证书不能用于登录
+	hostCert := string(ssh.MarshalAuthorizedKey(signCert(t, ca, pub, ssh.HostCert, "host", "abc")))
+	if _, err := ConnectSSHWithOptions(host, "abc", "", key, &SSHOptions{Certificates: []string{hostCert}}, nil, nil); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestSSHHostCertificate(t *testing.T) {
+	ca, hostKey := newHostKey(t), newHostKey(t)
+	startCertServer := func(principals ...string) (*sshd.Server, string) {
+		signer, err := ssh.NewCertSigner(signCert(t, ca, hostKey.PublicKey(), ssh.HostCert, "host", principals...), hostKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return startSSHSim(t, simConfig{HostKey: signer})
+	}
+	caKeys := []string{"# host ca\n" + string(ssh.MarshalAuthorizedKey(ca.PublicKey()))}
+
+	listener, host := startCertServer("127.0.0.1")
+	defer listener.Close()
+	if err := connectWithPolicy(host, &HostKeyPolicy{CAKeys: caKeys}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其它 CA 签发的
+	otherCA := []string{string(ssh.MarshalAuthorizedKey(newHostKey(t).PublicKey()))}
+	if err := connectWithPolicy(host, &HostKeyPolicy{CAKeys: otherCA}); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("want invalid certificate got %v", err)
+	}
+
+	// principals 中没有主机名
+	listener2, host2 := startCertServer("example.com")
+	defer listener2.Close()
+	if err := connectWithPolicy(host2, &HostKeyPolicy{CAKeys: caKeys}); err == nil || !strings.Contains(err.Error(), "principal") {
+		t.Errorf("want invalid principal got %v", err)
+	}
+
+	// 不是证书时按 Mode 验证, Mode 为空时拒绝
+	listener3, host3 := startSSHSim(t, simConfig{HostKey: hostKey})
+	defer listener3.Close()
+	if err := connectWithPolicy(host3, &HostKeyPolicy{CAKeys: caKeys}); !errors.Is(err, ErrHostKeyUnknown) {
+		t.Errorf("want unknown got %v", err)
+	}
+	if err := connectWithPolicy(host3, &HostKeyPolicy{CAKeys: caKeys, Fingerprints: []string{ssh.FingerprintSHA256(hostKey.PublicKey())}}); err != nil {
+		t.Error(err)
+	}
+
+	// 没有 CA 时 tofu 记住证书中的公钥
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := connectWithPolicy(host, &HostKeyPolicy{Mode: HostKeyTOFU, KnownHostsFiles: []string{knownHosts}}); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := os.ReadFile(knownHosts); !strings.Contains(string(bs), " ssh-ed25519 ") {
+		t.Errorf("known_hosts is invalid, %q", bs)
+	}
+	if err := connectWithPolicy(host, &HostKeyPolicy{Mode: HostKeyStrict, KnownHostsFiles: []string{knownHosts}}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := (&HostKeyPolicy{CAKeys: []string{"# empty"}}).Callback(); err == nil {
+		t.Error("want error")
+	}
+}
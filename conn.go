@@ -3,6 +3,7 @@ package shell
 import (
 	"context"
 	"io"
+	"regexp"
 	"time"
 )
 
@@ -13,19 +14,44 @@ type SendPasswordWriter interface {
 type Conn interface {
 	io.ReadWriteCloser
 
+	// SetReadDeadline 设置空闲超时, 即多长时间没有收到任何数据
 	SetReadDeadline(t time.Duration) error
 	SetWriteDeadline(t time.Duration) error
+	// SetExpectTimeout 设置单次 Expect 的超时, 0 表示不限制
+	SetExpectTimeout(t time.Duration) error
+	// SetDeadline 设置整个操作的截止时间, 零值表示不限制
+	SetDeadline(t time.Time) error
+	// SetTimeoutPolicy 设置 Expect 在空闲超时后的处理策略, nil 表示使用 DefaultTimeoutPolicy
+	SetTimeoutPolicy(policy TimeoutPolicy)
+	TimeoutPolicy() TimeoutPolicy
 
 	SetTeeWriter(w io.Writer) context.CancelFunc
 	SetTeeReader(w io.Writer) context.CancelFunc
 	SetTeeOutput(w io.Writer) context.CancelFunc
 
+	// SendSignal 中断正在执行的命令等, telnet 为 IAC IP, ssh 为 signal 请求,
+	// 不支持带外信号的连接在数据流中发送 Ctrl-C(只支持 SignalInterrupt)
+	SendSignal(sig Signal) error
+	// SendBreak 发送 break, telnet 为 IAC BRK, ssh 为 break 请求, 串口为线路 break
+	SendBreak() error
+	// Resize 改变终端窗口的大小, ssh 为 window-change 请求, telnet 为 NAWS 子协商
+	Resize(cols, rows int) error
+
+	// Metadata 返回连接的方式, 地址和协商的结果等, 不知道时返回 nil
+	Metadata() *Metadata
+
+	// ExitStatus 返回会话结束的原因和远端的退出码, 会话还没有结束时返回 nil
+	ExitStatus() *ExitStatus
+	// WaitExit 等待会话结束, 如发送 exit 或 reload 之后
+	WaitExit(ctx context.Context) (*ExitStatus, error)
+
 	UseCRLF()
 	Send([]byte) error
 	Sendln([]byte) error
 	SendPasswordWriter
 	DrainOff(time.Duration) (int, error)
 	Expect([][]byte) (int, []byte, error)
+	ExpectRegexp(context.Context, [][]byte, []*regexp.Regexp) (int, []byte, [][]byte, error)
 }
 
 type DoFunc func(conn Conn, bs []byte, idx int) (bool, error)
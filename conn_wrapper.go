@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"regexp"
 	"sync/atomic"
 	"time"
 	"unicode"
@@ -33,18 +34,33 @@ type ConnWrapper struct {
 	readByte interface {
 		ReadByte() (byte, error)
 	}
+	readByteContext interface {
+		ReadByteContext(ctx context.Context) (byte, error)
+	}
+	readContext interface {
+		ReadContext(ctx context.Context, p []byte) (int, error)
+	}
 	setReadDeadline interface {
 		SetReadDeadline(t time.Duration) error
 	}
 	setWriteDeadline interface {
 		SetWriteDeadline(t time.Duration) error
 	}
-	drainto drainto
+	drainto  drainto
+	signaler Signaler
+	resizer  Resizer
+	exit     *exitRecorder
+	metadata func() *Metadata
 
 	teeR atomic.Value
 	teeW atomic.Value
 
 	useCRLF bool
+
+	idleTimeout   time.Duration
+	expectTimeout time.Duration
+	deadline      time.Time
+	timeoutPolicy TimeoutPolicy
 }
 
 func (c *ConnWrapper) UseCRLF() {
@@ -58,36 +74,64 @@ func (c *ConnWrapper) Init(closer io.Closer, w io.Writer, r io.Reader) {
 	c.readByte, _ = r.(interface {
 		ReadByte() (byte, error)
 	})
+	c.readByteContext, _ = r.(interface {
+		ReadByteContext(ctx context.Context) (byte, error)
+	})
+	c.readContext, _ = r.(interface {
+		ReadContext(ctx context.Context, p []byte) (int, error)
+	})
 	c.setReadDeadline, _ = r.(interface {
 		SetReadDeadline(t time.Duration) error
 	})
 	c.drainto, _ = r.(drainto)
+	c.signaler, _ = closer.(Signaler)
+	c.resizer, _ = closer.(Resizer)
+	if provider, ok := closer.(MetadataProvider); ok {
+		c.metadata = provider.Metadata
+	}
 	c.setWriteDeadline, _ = w.(interface {
 		SetWriteDeadline(t time.Duration) error
 	})
 }
 
 func (c *ConnWrapper) Close() error {
+	if c.exit != nil {
+		c.exit.markClosing()
+	}
 	if c.session == nil {
 		return nil
 	}
 	return c.session.Close()
 }
 
-func (c *ConnWrapper) readUntil(buf *bytes.Buffer, delims [][]byte) (int, error) {
-	if len(delims) == 0 {
-		return 0, nil
+// maxRegexpLineSize 为正则表达式匹配时当前行的最大长度, 超过时只匹配最后的这部分
+const maxRegexpLineSize = 4096
+
+func (c *ConnWrapper) readUntil(ctx context.Context, buf *bytes.Buffer, delims [][]byte, regexps []*regexp.Regexp) (int, [][]byte, error) {
+	if len(delims) == 0 && len(regexps) == 0 {
+		return 0, nil, nil
 	}
-	p := make([][]byte, len(delims))
 	for i, s := range delims {
 		if len(s) == 0 {
-			return i, nil
+			return i, nil, nil
 		}
-		p[i] = s
+	}
+	var m *automaton
+	if len(delims) > 0 {
+		m = getAutomaton(delims)
+	}
+	state := int32(0)
+
+	if len(regexps) > 0 && buf == nil {
+		buf = &bytes.Buffer{}
+	}
+	lineStart := 0
+	if buf != nil {
+		lineStart = buf.Len()
 	}
 
 	for {
-		b, err := c.ReadByte()
+		b, err := c.ReadByteContext(ctx)
 		if err != nil {
 			if IsTimeout(err) {
 				if buf != nil {
@@ -96,59 +140,53 @@ func (c *ConnWrapper) readUntil(buf *bytes.Buffer, delims [][]byte) (int, error)
 					if bytes.HasSuffix(bs, []byte("#")) {
 						for i := range delims {
 							if bytes.Equal(delims[i], []byte("#")) {
-								return i, nil
+								return i, nil, nil
 							}
 						}
 					}
 				}
+				if _, ok := err.(*TimeoutError); !ok {
+					err = &TimeoutError{Kind: IdleTimeout, Duration: c.idleTimeout}
+				}
 			}
-			return -1, err
+			return -1, nil, err
 		}
 		if buf != nil {
 			buf.WriteByte(b)
 		}
 
-		for i := range p {
-			if p[i][0] != b {
-
-				// fmt.Println("b=", string([]byte{b}), ", end=", string(p[i]))
-				// fmt.Println("alreadyRecv=", string(alreadyRecv))
-				// fmt.Println("crossingMatch=", n, string(delims[i]))
-				// fmt.Println("newdelims=", string(delims[i][n:]))
-
-				alreadyRecvSize := len(delims[i]) - len(p[i])
-				alreadyRecv := delims[i][:alreadyRecvSize]
-				// 注意 下面一句 是不可以改成 crossingMatch(append(alreadyRecv, b), delims[i])
-				// 因为 append() 会修改 alreadyRecv，也可以会导致 delims[i] 的内容被修改
-				n := crossingMatch2(alreadyRecv, b, delims[i])
-				p[i] = delims[i][n:]
-
-				//p[i] = delims[i]
-				// if p[i][0] == b {
-				// 	p[i] = p[i][1:]
-				// }
-			} else {
-				// fmt.Println("b=", string([]byte{b}), ", end=", string(p[i]))
-				p[i] = p[i][1:]
+		if m != nil {
+			state = m.step(state, b)
+			for _, i := range m.outputs[state] {
+				if buf != nil && SkipHits(buf.Bytes(), delims[i]) {
+					continue
+				}
+				return i, nil, nil
 			}
+		}
 
-			if len(p[i]) == 0 {
-				if buf != nil {
-					bs := buf.Bytes()
-					if SkipHits(bs, delims[i]) {
-						p[i] = delims[i]
-						continue
-					}
+		if len(regexps) > 0 {
+			// 正则表达式只在当前行(最后一个换行之后收到的数据)上匹配
+			line := buf.Bytes()[lineStart:]
+			if len(line) > maxRegexpLineSize {
+				line = line[len(line)-maxRegexpLineSize:]
+			}
+			for i, re := range regexps {
+				if submatches := re.FindSubmatch(line); submatches != nil {
+					return len(delims) + i, submatches, nil
 				}
-
-				return i, nil
+			}
+			if b == '\n' {
+				lineStart = buf.Len()
 			}
 		}
 	}
 	// panic(nil)
 }
 
+// SetReadDeadline 设置空闲超时, 即多长时间没有收到任何数据时读操作返回 IdleTimeout
 func (c *ConnWrapper) SetReadDeadline(t time.Duration) error {
+	c.idleTimeout = t
 	if c.setReadDeadline == nil {
 		return nil //c.rc.SetTimeout(t)
 	}
@@ -164,10 +202,109 @@ func (c *ConnWrapper) SetWriteDeadline(t time.Duration) error {
 	return c.setWriteDeadline.SetWriteDeadline(t)
 }
 
+// SetExpectTimeout 设置单次 Expect(包括其中自动回答问题的时间)的超时, 0 表示不限制
+func (c *ConnWrapper) SetExpectTimeout(t time.Duration) error {
+	c.expectTimeout = t
+	return nil
+}
+
+// SetDeadline 设置整个操作的截止时间, 零值表示不限制
+func (c *ConnWrapper) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+// expectContext 返回按 SetExpectTimeout 和 SetDeadline 设置了超时的 ctx,
+// 超时后 context.Cause(ctx) 为 *TimeoutError
+func (c *ConnWrapper) expectContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeouts(ctx, c.expectTimeout, c.deadline)
+}
+
+func (c *ConnWrapper) SendSignal(sig Signal) error {
+	if c.signaler != nil {
+		return c.signaler.SendSignal(sig)
+	}
+	if sig == SignalInterrupt {
+		return c.Send([]byte{3})
+	}
+	return ErrSignalUnsupported
+}
+
+func (c *ConnWrapper) SendBreak() error {
+	if c.signaler != nil {
+		return c.signaler.SendBreak()
+	}
+	return ErrBreakUnsupported
+}
+
+func (c *ConnWrapper) Resize(cols, rows int) error {
+	if c.resizer != nil {
+		return c.resizer.Resize(cols, rows)
+	}
+	return ErrResizeUnsupported
+}
+
+// Metadata 返回连接的元数据, 不知道时返回 nil
+func (c *ConnWrapper) Metadata() *Metadata {
+	if c.metadata == nil {
+		return nil
+	}
+	return c.metadata()
+}
+
+// ExitStatus 返回会话结束的状态, 会话还没有结束或不支持时返回 nil
+func (c *ConnWrapper) ExitStatus() *ExitStatus {
+	if c.exit == nil {
+		return nil
+	}
+	return c.exit.ExitStatus()
+}
+
+// WaitExit 等待会话结束并返回它的状态
+func (c *ConnWrapper) WaitExit(ctx context.Context) (*ExitStatus, error) {
+	if c.exit == nil {
+		return nil, ErrExitStatusUnsupported
+	}
+	return c.exit.WaitExit(ctx)
+}
+
+func (c *ConnWrapper) SetTimeoutPolicy(policy TimeoutPolicy) {
+	c.timeoutPolicy = policy
+}
+
+// TimeoutPolicy 返回 Expect 在空闲超时后的处理策略, 没有设置时为 DefaultTimeoutPolicy
+func (c *ConnWrapper) TimeoutPolicy() TimeoutPolicy {
+	if c.timeoutPolicy == nil {
+		return DefaultTimeoutPolicy
+	}
+	return c.timeoutPolicy
+}
+
 func (c *ConnWrapper) Expect(delims [][]byte) (int, []byte, error) {
+	ctx, cancel := c.expectContext(context.Background())
+	defer cancel()
+
+	idx, bs, _, err := c.ExpectRegexp(ctx, delims, nil)
+	return idx, bs, err
+}
+
+// ExpectRegexp 同时用 delims 和 regexps 来匹配, 匹配 regexps[i] 时返回的序号为 len(delims)+i,
+// 同时返回它的子匹配. 正则表达式只在当前行上匹配, 所以 ^ 表示行首, $ 表示已收到的数据的末尾.
+// 注意每收到一个字节都会匹配一次, 所以末尾为 \S+ 之类时要加上结束符, 如 `version (\S+)\s`.
+// ctx 被取消时会立即返回 ctx.Err(), SetExpectTimeout 和 SetDeadline 的超时由调用者(如 Expect)加到 ctx 上
+func (c *ConnWrapper) ExpectRegexp(ctx context.Context, delims [][]byte, regexps []*regexp.Regexp) (int, []byte, [][]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	var buf bytes.Buffer
-	idx, err := c.readUntil(&buf, delims)
-	return idx, buf.Bytes(), err
+	idx, submatches, err := c.readUntil(ctx, &buf, delims, regexps)
+	if err != nil {
+		if ctxErr := ctxError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+	}
+	return idx, buf.Bytes(), submatches, err
 }
 
 var ln = []byte("\n")
@@ -241,17 +378,56 @@ func (c *ConnWrapper) Read(p []byte) (int, error) {
 	return n, err
 }
 
-func (c *ConnWrapper) ReadByte() (byte, error) {
-	var bs [1]byte
+// ReadContext 和 Read 一样, 但 ctx 被取消时会立即返回 ctx.Err(),
+// 底层不支持 ctx 时只能在每次读之前检查 ctx
+func (c *ConnWrapper) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c.readContext == nil {
+		return c.Read(p)
+	}
 
+	n, err := c.readContext.ReadContext(ctx, p)
+	if n > 0 {
+		c.teeReader().Write(p[:n])
+	}
+	return n, err
+}
+
+// ReadByteContext 和 ReadByte 一样, 但 ctx 被取消时会立即返回 ctx.Err(),
+// 底层不支持 ctx 时只能在每次读之前检查 ctx
+func (c *ConnWrapper) ReadByteContext(ctx context.Context) (byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c.readByteContext == nil {
+		return c.ReadByte()
+	}
+
+	b, err := c.readByteContext.ReadByteContext(ctx)
+	if err == nil {
+		c.teeByte(b)
+	}
+	return b, err
+}
+
+func (c *ConnWrapper) ReadByte() (byte, error) {
 	if c.readByte != nil {
 		b, err := c.readByte.ReadByte()
 		if err == nil {
-			bs[0] = b
-			c.teeReader().Write(bs[:])
+			c.teeByte(b)
 		}
 		return b, err
 	}
+
+	var bs [1]byte
 	n, err := c.Read(bs[:])
 	if err != nil {
 		return 0, err
@@ -282,6 +458,15 @@ func (c *ConnWrapper) teeWriter() io.Writer {
 	return ioutil.Discard
 }
 
+// teeByte 将读到的单个字节写到 teeReader 中, 没有设置时不做任何事(避免每个字节都分配内存)
+func (c *ConnWrapper) teeByte(b byte) {
+	o, _ := c.teeR.Load().(*wout)
+	if o == nil || o.Writer == nil {
+		return
+	}
+	o.Writer.Write([]byte{b})
+}
+
 func (c *ConnWrapper) teeReader() io.Writer {
 	o := c.teeR.Load()
 	if o == nil {
@@ -2,7 +2,12 @@ package shell
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
 	"testing"
+	"time"
 )
 
 func TestExpect(t *testing.T) {
@@ -42,4 +47,222 @@ func TestExpect(t *testing.T) {
 	}
 }
 
+func TestExpectContext(t *testing.T) {
+	p := MakePipe(0)
+	wrapper := MakeConnWrapper(nil, nil, p)
+	p.Write([]byte("abc"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, recvBytes, _, err := wrapper.ExpectRegexp(ctx, [][]byte{[]byte("abcd")}, nil)
+	if err != context.Canceled {
+		t.Errorf("want %v got %v", context.Canceled, err)
+	}
+	if string(recvBytes) != "abc" {
+		t.Errorf("want 'abc' got %q", recvBytes)
+	}
+	if elapsed := time.Since(start); elapsed > 1*time.Second {
+		t.Errorf("cancel is too slow, %s", elapsed)
+	}
+
+	n, err := p.ReadContext(ctx, make([]byte, 4))
+	if n != 0 || err != context.Canceled {
+		t.Errorf("want 0, %v got %d, %v", context.Canceled, n, err)
+	}
+}
+
+func TestExpectRegexp(t *testing.T) {
+	for _, test := range []struct {
+		data       string
+		pattern    string
+		found      bool
+		received   string
+		submatches []string
+	}{
+		{data: "Router(config)#", pattern: `^\S+(\(config[^)]*\))?#\s*$`, found: true,
+			received: "Router(config)#", submatches: []string{"Router(config)#", ""}},
+		{data: "Router(config)#", pattern: `^[^\s(]+(\(config[^)]*\))?#\s*$`, found: true,
+			received: "Router(config)#", submatches: []string{"Router(config)#", "(config)"}},
+		{data: "abc\r\nRouter# ", pattern: `^\S+(\(config[^)]*\))?#\s*$`, found: true,
+			received: "abc\r\nRouter#", submatches: []string{"Router#", ""}},
+		{data: "abc Router#", pattern: `^\S+#\s*$`, found: false},
+		{data: "abc\r\nversion 1.2.3\r\n", pattern: `version ([0-9.]+)\s`, found: true,
+			received: "abc\r\nversion 1.2.3\r", submatches: []string{"version 1.2.3\r", "1.2.3"}},
+	} {
+		p := MakePipe(0)
+		wrapper := MakeConnWrapper(nil, nil, p)
+		p.Write([]byte(test.data))
+		p.Close()
+
+		idx, received, submatches, err := wrapper.ExpectRegexp(context.Background(),
+			[][]byte{[]byte("adsbadsfsadfs22")},
+			[]*regexp.Regexp{regexp.MustCompile(test.pattern)})
+		if err != nil {
+			if test.found {
+				t.Errorf("%q match %q failed, %v", test.data, test.pattern, err)
+			}
+			continue
+		}
+		if !test.found {
+			t.Errorf("%q match %q, want fail got ok", test.data, test.pattern)
+			continue
+		}
+		if idx != 1 {
+			t.Errorf("%q match %q, want 1 got %d", test.data, test.pattern, idx)
+		}
+		if string(received) != test.received {
+			t.Errorf("%q match %q, want %q got %q", test.data, test.pattern, test.received, received)
+		}
+		var actual []string
+		for _, bs := range submatches {
+			actual = append(actual, string(bs))
+		}
+		if len(actual) != len(test.submatches) {
+			t.Errorf("%q match %q, want %q got %q", test.data, test.pattern, test.submatches, actual)
+			continue
+		}
+		for i := range actual {
+			if actual[i] != test.submatches[i] {
+				t.Errorf("%q match %q, want %q got %q", test.data, test.pattern, test.submatches, actual)
+				break
+			}
+		}
+	}
+}
+
+// legacyReadUntil 为以前逐个分隔符匹配的实现, 用来和自动机比较速度,
+// 注意它在重新开始匹配时会漏掉分隔符的第一个字节, 如在 "abba" 中找不到 "ba"
+func legacyReadUntil(r interface{ ReadByte() (byte, error) }, buf *bytes.Buffer, delims [][]byte) (int, error) {
+	p := make([][]byte, len(delims))
+	copy(p, delims)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return -1, err
+		}
+		buf.WriteByte(b)
+
+		for i := range p {
+			if p[i][0] != b {
+				alreadyRecv := delims[i][:len(delims[i])-len(p[i])]
+				n := crossingMatch2(alreadyRecv, b, delims[i])
+				p[i] = delims[i][n:]
+			} else {
+				p[i] = p[i][1:]
+			}
+
+			if len(p[i]) == 0 {
+				if SkipHits(buf.Bytes(), delims[i]) {
+					p[i] = delims[i]
+					continue
+				}
+				return i, nil
+			}
+		}
+	}
+}
+
+// naiveReadUntil 每收到一个字节都检查所有的分隔符是否为已收到数据的后缀
+func naiveReadUntil(r interface{ ReadByte() (byte, error) }, buf *bytes.Buffer, delims [][]byte) (int, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return -1, err
+		}
+		buf.WriteByte(b)
+
+		for i := range delims {
+			if bytes.HasSuffix(buf.Bytes(), delims[i]) && !SkipHits(buf.Bytes(), delims[i]) {
+				return i, nil
+			}
+		}
+	}
+}
+
+func TestAutomaton(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	randomBytes := func(n int) []byte {
+		bs := make([]byte, n)
+		for i := range bs {
+			bs[i] = "ab-# \n"[rnd.Intn(6)]
+		}
+		return bs
+	}
+
+	wrapper := MakeConnWrapper(nil, nil, bytes.NewReader([]byte("abba")))
+	if idx, _, err := wrapper.Expect([][]byte{[]byte("ba")}); err != nil || idx != 0 {
+		t.Error("want 0 got", idx, err)
+	}
+
+	for i := 0; i < 2000; i++ {
+		delims := make([][]byte, 1+rnd.Intn(5))
+		for j := range delims {
+			delims[j] = randomBytes(1 + rnd.Intn(4))
+		}
+		data := randomBytes(rnd.Intn(64))
+
+		var excepted bytes.Buffer
+		exceptedIdx, exceptedErr := naiveReadUntil(bytes.NewReader(data), &excepted, delims)
+
+		wrapper := MakeConnWrapper(nil, nil, bytes.NewReader(data))
+		idx, actual, err := wrapper.Expect(delims)
+		if idx != exceptedIdx || (err == nil) != (exceptedErr == nil) || !bytes.Equal(actual, excepted.Bytes()) {
+			t.Errorf("%q match %q, want %d %q %v got %d %q %v",
+				data, delims, exceptedIdx, excepted.Bytes(), exceptedErr, idx, actual, err)
+		}
+	}
+}
+
+// makeRunningConfig 生成一个类似 show running-config 的大约 size 个字节的输出
+func makeRunningConfig(size int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("show running-config\r\nBuilding configuration...\r\n\r\nCurrent configuration : 4194304 bytes\r\n!\r\n")
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, "interface GigabitEthernet1/0/%d\r\n", i)
+		fmt.Fprintf(&buf, " description uplink-to-access-switch-%d\r\n", i)
+		fmt.Fprintf(&buf, " switchport access vlan %d\r\n", i%4094+1)
+		buf.WriteString(" switchport mode access\r\n spanning-tree portfast\r\n!\r\n")
+	}
+	buf.WriteString("end\r\n\r\nRouter#")
+	return buf.Bytes()
+}
+
+func benchmarkPrompts() [][]byte {
+	prompts := [][]byte{[]byte("Router#")}
+	for _, m := range DefaultMatchers {
+		prompts = append(prompts, m.Prompts()...)
+	}
+	return prompts
+}
+
+func BenchmarkExpectRunningConfig(b *testing.B) {
+	data := makeRunningConfig(4 * 1024 * 1024)
+	prompts := benchmarkPrompts()
+
+	b.Run("automaton", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			wrapper := MakeConnWrapper(nil, nil, bytes.NewReader(data))
+			idx, _, err := wrapper.Expect(prompts)
+			if err != nil || idx != 0 {
+				b.Fatal(idx, err)
+			}
+		}
+	})
+
+	b.Run("legacy", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			idx, err := legacyReadUntil(bytes.NewReader(data), &buf, prompts)
+			if err != nil || idx != 0 {
+				b.Fatal(idx, err)
+			}
+		}
+	})
+}
+
 // ,
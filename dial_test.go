@@ -0,0 +1,162 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
+)
+
+func TestDialSSH(t *testing.T) {
+	options := &sshd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", sshd.Echo)
+
+	listener, err := sshd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, "ssh://abc:123@"+net.JoinHostPort("127.0.0.1", listener.Port())+"?cipher=aes256-ctr&term=vt100&crlf=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(1 * time.Second)
+
+	if _, err := ReadPrompt(ctx, conn, [][]byte{[]byte(">")}); err != nil {
+		t.Fatal(err)
+	}
+	md := conn.Metadata()
+	if md.Transport != "ssh" || md.SSH.CipherClientToServer != "aes256-ctr" {
+		t.Errorf("unexpected %q %q", md.Transport, md.SSH.CipherClientToServer)
+	}
+}
+
+func TestDialSSHContext(t *testing.T) {
+	// 只接受连接但是不回应握手的服务
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	rawurl := "ssh://abc:123@" + ln.Addr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err := Dial(ctx, rawurl); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want deadline exceeded got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := Dial(ctx, rawurl); !errors.Is(err, context.Canceled) {
+		t.Errorf("want canceled got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancel is ignored, %v", elapsed)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := Dial(ctx, rawurl); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want deadline exceeded got %v", err)
+	}
+}
+
+func TestDialTelnet(t *testing.T) {
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.Echo)
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ctx := context.Background()
+	conn, err := Dial(ctx, "telnet://"+net.JoinHostPort("127.0.0.1", listener.Port())+"?term=VT100&cols=100&rows=30&crlf=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(1 * time.Second)
+
+	if _, err := UserLogin(ctx, conn, nil, []byte("abc"), nil, []byte("123"), nil); err != nil {
+		t.Fatal(err)
+	}
+	md := conn.Metadata()
+	if md.Transport != "telnet" || md.Telnet.TermType != "VT100" || md.Telnet.Columns != 100 || md.Telnet.Rows != 30 {
+		t.Errorf("unexpected %#v %#v", md, md.Telnet)
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		url string
+		err string
+	}{
+		{url: "abc://127.0.0.1", err: "scheme 'abc' is unsupported"},
+		{url: "telnet://127.0.0.1?cols=abc", err: "'cols' is invalid"},
+		{url: "ssh://127.0.0.1?keepalive=abc", err: "'keepalive' is invalid"},
+	} {
+		_, err := Dial(ctx, test.url)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: want %q got %v", test.url, test.err, err)
+		}
+	}
+}
+
+func TestRegisterDialer(t *testing.T) {
+	var dialed *url.URL
+	var sent bytes.Buffer
+	RegisterDialer("Fake", func(ctx context.Context, u *url.URL) (Conn, error) {
+		dialed = u
+		conn := MakeConnWrapper(nil, &sent, MakePipe(0))
+		return &conn, nil
+	})
+
+	found := false
+	for _, scheme := range Schemes() {
+		found = found || scheme == "fake"
+	}
+	if !found {
+		t.Errorf("fake is missing in %v", Schemes())
+	}
+
+	conn, err := Dial(context.Background(), "FAKE://device-1/abc?crlf=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dialed == nil || dialed.Host != "device-1" || dialed.Path != "/abc" {
+		t.Errorf("unexpected %#v", dialed)
+	}
+	if err := conn.Sendln([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if sent.String() != "abc\r\n" {
+		t.Errorf("want crlf got %q", sent.String())
+	}
+}
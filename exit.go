@@ -0,0 +1,181 @@
+package shell
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// CloseReason 为会话结束的原因
+type CloseReason int
+
+const (
+	// CloseNone 表示会话还没有结束
+	CloseNone CloseReason = iota
+	// CloseExit 表示远端的进程退出了, ExitStatus.Code 为退出码
+	CloseExit
+	// CloseSignal 表示远端的进程被信号终止了, ExitStatus.Signal 为信号
+	CloseSignal
+	// CloseEOF 表示对端正常关闭了连接, 但没有退出码, 如 telnet 或 ssh 中没有 exit-status
+	CloseEOF
+	// CloseReset 表示连接被重置或出错了
+	CloseReset
+	// CloseLocal 表示本地调用了 Close
+	CloseLocal
+	// ClosePeerDead 表示 keepalive 发现对端没有响应了
+	ClosePeerDead
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNone:
+		return "none"
+	case CloseExit:
+		return "exit"
+	case CloseSignal:
+		return "signal"
+	case CloseEOF:
+		return "eof"
+	case CloseReset:
+		return "reset"
+	case CloseLocal:
+		return "local"
+	case ClosePeerDead:
+		return "peer dead"
+	default:
+		return "unknown"
+	}
+}
+
+var ErrExitStatusUnsupported = errors.New("exit status is unsupported")
+
+// ExitStatus 为会话结束时的状态
+type ExitStatus struct {
+	Reason CloseReason
+	// Code 为远端的退出码, 只有 Reason 为 CloseExit 时才有意义, 其它时为 -1
+	Code int
+	// Signal 为终止远端进程的信号, 只在 Reason 为 CloseSignal 时有效
+	Signal Signal
+	// Message 为 ssh exit-signal 中的错误信息
+	Message string
+	// Err 为会话结束时的原始错误
+	Err error
+}
+
+// Success 判断远端是不是正常退出(退出码为 0)
+func (s *ExitStatus) Success() bool {
+	return s.Reason == CloseExit && s.Code == 0
+}
+
+func (s *ExitStatus) String() string {
+	switch s.Reason {
+	case CloseExit:
+		return "exit status " + strconv.Itoa(s.Code)
+	case CloseSignal:
+		if s.Message != "" {
+			return "signal " + string(s.Signal) + ", " + s.Message
+		}
+		return "signal " + string(s.Signal)
+	}
+	if s.Err != nil {
+		return s.Reason.String() + ", " + s.Err.Error()
+	}
+	return s.Reason.String()
+}
+
+// exitRecorder 记录会话结束的状态, 只有第一次设置的有效
+type exitRecorder struct {
+	closing int32
+	once    sync.Once
+	status  *ExitStatus
+	done    chan struct{}
+}
+
+func newExitRecorder() *exitRecorder {
+	return &exitRecorder{done: make(chan struct{})}
+}
+
+// markClosing 表示本地正在关闭连接, 这之后的 EOF, 连接错误和信号(如本地杀掉了 plink)都算作 CloseLocal
+func (r *exitRecorder) markClosing() {
+	atomic.StoreInt32(&r.closing, 1)
+}
+
+func (r *exitRecorder) set(status *ExitStatus) {
+	r.once.Do(func() {
+		switch status.Reason {
+		case CloseEOF, CloseReset, CloseSignal:
+			if atomic.LoadInt32(&r.closing) != 0 {
+				status.Reason = CloseLocal
+			}
+		}
+		r.status = status
+		close(r.done)
+	})
+}
+
+// setError 按 err 判断是对端关闭了连接还是连接出错了
+func (r *exitRecorder) setError(err error) {
+	var kerr *KeepaliveError
+	switch {
+	case errors.As(err, &kerr):
+		r.set(&ExitStatus{Reason: ClosePeerDead, Code: -1, Err: err})
+	case err == nil || err == io.EOF || errors.Is(err, io.EOF):
+		r.set(&ExitStatus{Reason: CloseEOF, Code: -1, Err: err})
+	case errors.Is(err, net.ErrClosed):
+		r.set(&ExitStatus{Reason: CloseLocal, Code: -1, Err: err})
+	default:
+		// 包括 ECONNRESET
+		r.set(&ExitStatus{Reason: CloseReset, Code: -1, Err: err})
+	}
+}
+
+func (r *exitRecorder) ExitStatus() *ExitStatus {
+	select {
+	case <-r.done:
+		return r.status
+	default:
+		return nil
+	}
+}
+
+func (r *exitRecorder) WaitExit(ctx context.Context) (*ExitStatus, error) {
+	select {
+	case <-r.done:
+		return r.status, nil
+	case <-ctx.Done():
+		return nil, ctxError(ctx)
+	}
+}
+
+// sshExitStatus 将 session.Wait() 的结果转换成 ExitStatus
+func sshExitStatus(err error) *ExitStatus {
+	if err == nil {
+		return &ExitStatus{Reason: CloseExit, Code: 0}
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Signal() != "" {
+			return &ExitStatus{
+				Reason:  CloseSignal,
+				Code:    -1,
+				Signal:  Signal(exitErr.Signal()),
+				Message: exitErr.Msg(),
+				Err:     err,
+			}
+		}
+		return &ExitStatus{Reason: CloseExit, Code: exitErr.ExitStatus(), Err: err}
+	}
+
+	var missingErr *ssh.ExitMissingError
+	if errors.As(err, &missingErr) {
+		return &ExitStatus{Reason: CloseEOF, Code: -1, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package shell
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim"
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
+)
+
+var exitCommands = sim.Commands{
+	"crash": func(s *sim.Session, line, args []byte) error {
+		return &sim.ExitStatus{Code: 3}
+	},
+	"kill": func(s *sim.Session, line, args []byte) error {
+		return &sim.ExitStatus{Signal: "KILL"}
+	},
+}
+
+func waitExit(t *testing.T, conn Conn) *ExitStatus {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status, err := conn.WaitExit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.ExitStatus() != status {
+		t.Errorf("ExitStatus() is different with WaitExit()")
+	}
+	return status
+}
+
+func TestSSHExitStatus(t *testing.T) {
+	options := &sshd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", sshd.OS(exitCommands))
+
+	listener, err := sshd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	for _, test := range []struct {
+		cmd    string
+		reason CloseReason
+		code   int
+		signal Signal
+	}{
+		{cmd: "exit", reason: CloseExit, code: 0},
+		{cmd: "crash", reason: CloseExit, code: 3},
+		{cmd: "kill", reason: CloseSignal, code: -1, signal: SignalKill},
+		{cmd: "", reason: CloseLocal, code: -1},
+	} {
+		name := test.cmd
+		if name == "" {
+			name = "close"
+		}
+		t.Run(name, func(t *testing.T) {
+			conn, err := ConnectSSH(net.JoinHostPort("127.0.0.1", listener.Port()), "abc", "123", "", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.UseCRLF()
+			conn.SetReadDeadline(1 * time.Second)
+
+			if _, err := ReadPrompt(context.Background(), conn, [][]byte{[]byte(">")}); err != nil {
+				t.Fatal(err)
+			}
+			if conn.ExitStatus() != nil {
+				t.Fatal("session is running")
+			}
+
+			if test.cmd == "" {
+				conn.Close()
+			} else if err := conn.Sendln([]byte(test.cmd)); err != nil {
+				t.Fatal(err)
+			}
+
+			status := waitExit(t, conn)
+			if status.Reason != test.reason || status.Code != test.code || status.Signal != test.signal {
+				t.Errorf("want %v/%d/%q got %v/%d/%q", test.reason, test.code, test.signal,
+					status.Reason, status.Code, status.Signal)
+			}
+			if status.Success() != (test.cmd == "exit") {
+				t.Errorf("Success() is %v", status.Success())
+			}
+		})
+	}
+}
+
+func TestTelnetExitStatus(t *testing.T) {
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.OS(exitCommands))
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	for _, test := range []struct {
+		cmd    string
+		reason CloseReason
+	}{
+		{cmd: "exit", reason: CloseEOF},
+		{cmd: "", reason: CloseLocal},
+	} {
+		t.Run(test.reason.String(), func(t *testing.T) {
+			telnetConn, err := DialTelnetTimeout("tcp", net.JoinHostPort("127.0.0.1", listener.Port()), 1*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := TelnetWrap(telnetConn, nil, nil)
+			defer conn.Close()
+			conn.UseCRLF()
+			conn.SetReadDeadline(1 * time.Second)
+
+			if _, err := UserLogin(context.Background(), conn, nil, []byte("abc"), nil, []byte("123"), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.cmd == "" {
+				conn.Close()
+			} else if err := conn.Sendln([]byte(test.cmd)); err != nil {
+				t.Fatal(err)
+			}
+
+			if status := waitExit(t, conn); status.Reason != test.reason || status.Code != -1 {
+				t.Errorf("want %v got %v", test.reason, status)
+			}
+		})
+	}
+}
+
+func TestTelnetReset(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("login:"))
+		time.Sleep(100 * time.Millisecond)
+		// 关闭时发送 RST 而不是 FIN
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}()
+
+	telnetConn, err := DialTelnetTimeout("tcp", listener.Addr().String(), 1*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := TelnetWrap(telnetConn, nil, nil)
+	defer conn.Close()
+
+	if status := waitExit(t, conn); status.Reason != CloseReset {
+		t.Errorf("want reset got %v", status)
+	}
+}
@@ -0,0 +1,128 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim"
+	"github.com/mei-rune/shell/sim/telnetd"
+)
+
+func startFaultsSim(t *testing.T, faults *sim.Faults) (*telnetd.Server, Conn, []byte) {
+	return startFaultsSimWithWriter(t, faults, nil)
+}
+
+// startFaultsSimWithWriter 和 startFaultsSim 相同, sWriter 记录服务端发来的原始数据
+func startFaultsSimWithWriter(t *testing.T, faults *sim.Faults, sWriter io.Writer) (*telnetd.Server, Conn, []byte) {
+	listener, host := startTelnetSim(t, simConfig{
+		Faults: faults,
+		Handler: telnetd.OS(telnetd.Commands{
+			"show": telnetd.WithMore([]string{
+				"abcd",
+				"efgh",
+				"ijklmn",
+			}, []byte("-- more --"), nil),
+		}),
+	})
+
+	telnetConn, err := DialTelnetTimeout("tcp", host, 1*time.Second)
+	if err != nil {
+		listener.Close()
+		t.Fatal(err)
+	}
+	conn := TelnetWrap(telnetConn, sWriter, nil)
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	prompt, err := UserLogin(context.Background(), conn, nil, []byte("abc"), nil, []byte("123"), nil)
+	if err != nil {
+		conn.Close()
+		listener.Close()
+		t.Fatal(err)
+	}
+	return listener, conn, prompt
+}
+
+func TestSimFaultsLatencyAndSplit(t *testing.T) {
+	listener, conn, prompt := startFaultsSim(t, &sim.Faults{
+		Seed:      1,
+		Latency:   5 * time.Millisecond,
+		SplitSize: 3,
+	})
+	defer listener.Close()
+	defer conn.Close()
+
+	testSimSimple(t, context.Background(), conn, prompt)
+}
+
+func TestSimFaultsEcho(t *testing.T) {
+	var raw bytes.Buffer
+	listener, conn, prompt := startFaultsSimWithWriter(t, &sim.Faults{
+		Seed:            2,
+		DuplicateEcho:   true,
+		BackspaceRedraw: true,
+		NULPadding:      2,
+	}, &raw)
+	defer listener.Close()
+	defer conn.Close()
+
+	if string(prompt) != "ABC>" {
+		t.Errorf("want 'ABC>' got %q", prompt)
+	}
+	testSimSimple(t, context.Background(), conn, prompt)
+
+	// 确认故障确实出现在原始数据中
+	for _, fault := range []struct {
+		name string
+		want string
+	}{
+		{"duplicate echo", "eecchhoo  aabbccdd"},
+		{"backspace redraw", strings.Repeat("\b", len("echo abcd")) + "echo abcd"},
+		{"NUL padding", "\n\x00\x00"},
+	} {
+		if !strings.Contains(raw.String(), fault.want) {
+			t.Errorf("%s isn't found in %q", fault.name, raw.String())
+		}
+	}
+}
+
+func TestSimFaultsDropMore(t *testing.T) {
+	listener, conn, prompt := startFaultsSim(t, &sim.Faults{
+		Seed:     3,
+		DropMore: 1,
+	})
+	defer listener.Close()
+	defer conn.Close()
+
+	output, err := Exec(context.Background(), conn, prompt, []byte("show"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, s := range []string{"abcd", "efgh", "ijklmn"} {
+		if !strings.Contains(string(output), s) {
+			t.Errorf("want %q got %q", s, output)
+		}
+	}
+	if strings.Contains(string(output), "-- more --") {
+		t.Errorf("more is dropped, got %q", output)
+	}
+}
+
+func TestSimFaultsDisconnect(t *testing.T) {
+	listener, conn, prompt := startFaultsSim(t, &sim.Faults{
+		Seed:            4,
+		DisconnectAfter: len("username:password:ABC>") + len("show\r\nshow\r\nab"),
+	})
+	defer listener.Close()
+	defer conn.Close()
+
+	output, err := Exec(context.Background(), conn, prompt, []byte("show"))
+	if err == nil {
+		t.Errorf("want error got %q", output)
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/runner-mei/errors v0.0.0-20220725054952-d7c9c10762ea
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
 	golang.org/x/crypto v0.14.0
+	golang.org/x/sys v0.13.0
 	golang.org/x/text v0.13.0
 )
 
@@ -16,5 +17,4 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	golang.org/x/sys v0.13.0 // indirect
 )
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -155,6 +157,60 @@ var Placeholders = []struct {
 			}
 		},
 	},
+	{
+		// <<$N>> 为最近一次匹配的正则表达式的第 N 个子匹配
+		Placeholder: []byte("<<$"),
+		Replace: func(conn *Shell, sendbuf []byte) ([]byte, error) {
+			var err error
+			sendbuf = submatchPlaceholder.ReplaceAllFunc(sendbuf, func(tag []byte) []byte {
+				n, _ := strconv.Atoi(string(tag[3 : len(tag)-2]))
+				if n >= len(conn.Submatches) {
+					err = errors.New("子匹配 '" + string(tag) + "' 不存在")
+					return tag
+				}
+				return conn.Submatches[n]
+			})
+			return sendbuf, err
+		},
+	},
+}
+
+var submatchPlaceholder = regexp.MustCompile(`<<\$[0-9]+>>`)
+
+// parseRegexp 解析 re"..." 格式的正则表达式, 不是这个格式时返回 nil
+func parseRegexp(text []byte) (*regexp.Regexp, error) {
+	text = bytes.TrimSpace(text)
+	if !bytes.HasPrefix(text, []byte(`re"`)) {
+		return nil, nil
+	}
+	word, remain, err := readRawQuoteString([]rune(string(text[3:])))
+	if err != nil {
+		return nil, errors.New("参数语法不正确: " + err.Error())
+	}
+	if _, remain = skipWhitespace(remain); len(remain) > 0 {
+		return nil, errors.New("参数语法不正确, 正则表达式之后有多余的字符 '" + string(remain) + "'")
+	}
+	re, err := regexp.Compile(string(word))
+	if err != nil {
+		return nil, errors.New("正则表达式不正确: " + err.Error())
+	}
+	return re, nil
+}
+
+// parseTimeout 解析 @@xxx_timeout 指令的参数, 参数为空时返回 defaultValue
+func parseTimeout(name string, text []byte, defaultValue time.Duration) (time.Duration, error) {
+	text = bytes.TrimSpace(text)
+	if len(text) == 0 {
+		if defaultValue <= 0 {
+			return 0, errors.New(name + " is missing")
+		}
+		return defaultValue, nil
+	}
+	timeout, err := time.ParseDuration(string(text))
+	if err != nil {
+		return 0, errors.New(name + " is invalid - " + string(text))
+	}
+	return timeout, nil
 }
 
 func RegisterPlaceholder(name string) {
@@ -193,11 +249,18 @@ var SubParsers = map[string]func(*Script, int, int, string, []byte, *Script) err
 		}
 
 		var words [][]byte
+		var regexps []*regexp.Regexp
 		var alreadyMore bool
 		for idx := range types {
 			switch types[idx] {
 			case 0:
-				if charsets[idx] == "" {
+				if charsets[idx] == "re" {
+					re, err := regexp.Compile(ss[idx])
+					if err != nil {
+						return errors.New("正则表达式不正确: " + err.Error())
+					}
+					regexps = append(regexps, re)
+				} else if charsets[idx] == "" {
 					words = append(words, []byte(string(ss[idx])))
 				} else {
 					bs, err := toBytes(ss[idx], charsets[idx])
@@ -217,7 +280,7 @@ var SubParsers = map[string]func(*Script, int, int, string, []byte, *Script) err
 			}
 		}
 
-		if len(words) == 0 {
+		if len(words) == 0 && len(regexps) == 0 {
 			return errors.New("参数语法不正确, 匹配字符没有")
 		}
 
@@ -226,7 +289,7 @@ var SubParsers = map[string]func(*Script, int, int, string, []byte, *Script) err
 				LineNumber: start,
 				LineText:   rawText,
 				Run: func(ctx context.Context, script *Script, conn *Shell) error {
-					conn.On(words, DoFunc(func(conn *Shell, idx int) (bool, error) {
+					run := DoFunc(func(conn *Shell, idx int) (bool, error) {
 						results, err := conn.RunScript(ctx, subScript)
 						if alreadyMore {
 							if err == nil {
@@ -245,7 +308,15 @@ var SubParsers = map[string]func(*Script, int, int, string, []byte, *Script) err
 							results: results,
 							err:     err,
 						}
-					}))
+					})
+					if len(words) > 0 {
+						conn.On(words, run)
+					}
+					if len(regexps) > 0 {
+						conn.OnSubmatch(regexps, SubmatchFunc(func(conn *Shell, idx int, submatches [][]byte) (bool, error) {
+							return run(conn, idx)
+						}))
+					}
 					return nil
 				}})
 		return nil
@@ -409,6 +480,24 @@ var Parsers = map[string]func(*Script, int, string, []byte) error{
 		return nil
 	},
 	"@echo": func(script *Script, line int, rawText string, copyed []byte) error {
+		re, err := parseRegexp(copyed)
+		if err != nil {
+			return err
+		}
+		if re != nil {
+			script.Cmds = append(script.Cmds,
+				Command{
+					LineNumber: line,
+					LineText:   rawText,
+					Run: func(ctx context.Context, script *Script, conn *Shell) error {
+						return shell.Expect(ctx, conn.Conn, shell.MatchRegexp(re, func(c shell.Conn, bs []byte, idx int, submatches [][]byte) (bool, error) {
+							conn.Submatches = submatches
+							return false, nil
+						}))
+					}})
+			return nil
+		}
+
 		copyed = escapeBytes(copyed)
 
 		excepted := bytes.Split(copyed, []byte("$$$$$$$$"))
@@ -435,12 +524,39 @@ var Parsers = map[string]func(*Script, int, string, []byte) error{
 				LineNumber: line,
 				LineText:   rawText,
 				Run: func(ctx context.Context, script *Script, conn *Shell) error {
-					time.Sleep(timeout)
-					return nil
+					if ctx == nil {
+						time.Sleep(timeout)
+						return nil
+					}
+
+					timer := time.NewTimer(timeout)
+					defer timer.Stop()
+
+					select {
+					case <-timer.C:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 				}})
 		return nil
 	},
 	"@prompt": func(script *Script, line int, rawText string, copyed []byte) error {
+		re, err := parseRegexp(copyed)
+		if err != nil {
+			return err
+		}
+		if re != nil {
+			script.Cmds = append(script.Cmds,
+				Command{
+					LineNumber: line,
+					LineText:   rawText,
+					Run: func(ctx context.Context, script *Script, conn *Shell) error {
+						return conn.ReadPromptRegexp(ctx, re)
+					}})
+			return nil
+		}
+
 		copyed = escapeBytes(copyed)
 
 		script.Cmds = append(script.Cmds,
@@ -519,13 +635,85 @@ var Parsers = map[string]func(*Script, int, string, []byte) error{
 		return nil
 	},
 	"@@read_timeout": func(script *Script, line int, rawText string, copyed []byte) error {
-		timeout := 10 * time.Second
-		if len(copyed) > 0 {
-			to, err := time.ParseDuration(string(copyed))
+		timeout, err := parseTimeout("read_timeout", copyed, 10*time.Second)
+		if err != nil {
+			return err
+		}
+
+		script.Cmds = append(script.Cmds,
+			Command{
+				LineNumber: line,
+				LineText:   rawText,
+				Run: func(ctx context.Context, script *Script, conn *Shell) error {
+					conn.SetIdleTimeout(timeout)
+					return nil
+				}})
+		return nil
+	},
+	"@@idle_timeout": func(script *Script, line int, rawText string, copyed []byte) error {
+		timeout, err := parseTimeout("idle_timeout", copyed, 0)
+		if err != nil {
+			return err
+		}
+
+		script.Cmds = append(script.Cmds,
+			Command{
+				LineNumber: line,
+				LineText:   rawText,
+				Run: func(ctx context.Context, script *Script, conn *Shell) error {
+					conn.SetIdleTimeout(timeout)
+					return nil
+				}})
+		return nil
+	},
+	"@@expect_timeout": func(script *Script, line int, rawText string, copyed []byte) error {
+		timeout, err := parseTimeout("expect_timeout", copyed, 0)
+		if err != nil {
+			return err
+		}
+
+		script.Cmds = append(script.Cmds,
+			Command{
+				LineNumber: line,
+				LineText:   rawText,
+				Run: func(ctx context.Context, script *Script, conn *Shell) error {
+					conn.SetExpectTimeout(timeout)
+					return nil
+				}})
+		return nil
+	},
+	"@@total_timeout": func(script *Script, line int, rawText string, copyed []byte) error {
+		timeout, err := parseTimeout("total_timeout", copyed, 0)
+		if err != nil {
+			return err
+		}
+
+		script.Cmds = append(script.Cmds,
+			Command{
+				LineNumber: line,
+				LineText:   rawText,
+				Run: func(ctx context.Context, script *Script, conn *Shell) error {
+					conn.SetTotalTimeout(timeout)
+					return nil
+				}})
+		return nil
+	},
+	"@@timeout_policy": func(script *Script, line int, rawText string, copyed []byte) error {
+		a := bytes.Fields(copyed)
+		if len(a) == 0 || len(a) > 2 {
+			return errors.New("@@timeout_policy 指令不正确, 格式为 @@timeout_policy name [retries]")
+		}
+		retries := 0
+		if len(a) == 2 {
+			i, err := strconv.Atoi(string(a[1]))
 			if err != nil {
-				return errors.New("read_timeout is invalid - " + string(copyed))
+				return errors.New("@@timeout_policy 指令不正确, 重试次数 '" + string(a[1]) + "' 不是一个数字")
 			}
-			timeout = to
+			retries = i
+		}
+		policy, err := shell.ParseTimeoutPolicy(string(a[0]), retries)
+		if err != nil {
+			return err
 		}
 
 		script.Cmds = append(script.Cmds,
@@ -533,7 +721,7 @@ var Parsers = map[string]func(*Script, int, string, []byte) error{
 				LineNumber: line,
 				LineText:   rawText,
 				Run: func(ctx context.Context, script *Script, conn *Shell) error {
-					conn.Conn.SetReadDeadline(timeout)
+					conn.SetTimeoutPolicy(policy)
 					return nil
 				}})
 		return nil
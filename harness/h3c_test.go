@@ -8,9 +8,8 @@ import (
 	"strings"
 	"testing"
 
-	// "github.com/mei-rune/shell/sim/telnetd"
-	"tech.hengwei.com.cn/go/private/sim/sshd"
-	"tech.hengwei.com.cn/go/private/sim/telnetd"
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
 
 	"github.com/mei-rune/shell"
 )
@@ -248,6 +247,10 @@ func testTelnetH3C(t *testing.T, ctx context.Context, params *TelnetParam, hasVi
 }
 
 func testSSHH3C(t *testing.T, ctx context.Context, params *SSHParam, hasView bool) {
+	if params.UseExternalSSH {
+		skipIfNoPlink(t)
+	}
+
 	var buf bytes.Buffer
 	c, prompt, err := DailSSH(ctx, params, ClientWriter(&buf), ServerWriter(&buf), Question(AbcQuestion.Prompts(), AbcQuestion.Do()))
 
@@ -39,6 +39,22 @@ func TestParse(t *testing.T) {
 				[]string{"\r\n\tabc"},
 			},
 		},
+		{
+			text: `@trigger re"^\S+ \"(\d+)\"#$" "abc" {
+				@write aaa
+			}`,
+			cmdCount: 1,
+			questions: [][]string{
+				[]string{"abc"},
+				[]string{`^\S+ "(\d+)"#$`},
+			},
+		},
+		{
+			text: `@trigger re"(abc" {
+				@write aaa
+			}`,
+			err: "正则表达式不正确",
+		},
 		{
 			text: `@trigger "abc {
 				@write aaa
@@ -51,6 +67,22 @@ func TestParse(t *testing.T) {
 			}`,
 			err: "选项 'abc' 是未知的",
 		},
+		{
+			text:     "@@timeout_policy ctrl-c 3\n@@expect_timeout 1m\n@@total_timeout 10m",
+			cmdCount: 3,
+		},
+		{
+			text: `@@timeout_policy abc`,
+			err:  "timeout policy 'abc' is unknown",
+		},
+		{
+			text: `@@timeout_policy newline abc`,
+			err:  "不是一个数字",
+		},
+		{
+			text: `@@expect_timeout abc`,
+			err:  "expect_timeout is invalid",
+		},
 	} {
 		t.Run(test.text, func(t *testing.T) {
 			spt, err := ParseScript(strings.NewReader(test.text))
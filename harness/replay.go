@@ -0,0 +1,96 @@
+package harness
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/mei-rune/shell"
+)
+
+// ResultsTranscript 将 Script.Run 返回的结果转换为可以回放的 shell.Transcript
+//
+// ExecuteResult 中只有每一步收到和发送的全部数据, 没有它们之间的先后顺序,
+// 这里按回显和提示符来猜测客户端在什么时候发送数据, 只能尽力而为, 如果需要
+// 准确的回放请用 shell.TranscriptRecorder 记录会话
+func ResultsTranscript(results []ExecuteResult) shell.Transcript {
+	var t shell.Transcript
+	for _, result := range results {
+		interleave(&t, result.Incomming, result.Outgoing)
+	}
+	return t
+}
+
+func interleave(t *shell.Transcript, in, out string) {
+	for _, token := range splitOutgoing(out) {
+		idx := -1
+		if cmd := strings.TrimSpace(token); cmd != "" && cmd != "********" {
+			idx = indexEcho(in, cmd)
+		}
+		if idx < 0 {
+			idx = indexQuestionEnd(in)
+		}
+		t.Incoming(in[:idx])
+		t.Outgoing(token)
+		in = in[idx:]
+	}
+	t.Incoming(in)
+}
+
+// splitOutgoing 将发送的数据按行拆开, 最后不以换行结束的部分(如分页时的空格)
+// 每个字符都当作一次按键
+func splitOutgoing(out string) []string {
+	var tokens []string
+	for out != "" {
+		idx := strings.IndexByte(out, '\n')
+		if idx < 0 {
+			for _, c := range out {
+				tokens = append(tokens, string(c))
+			}
+			break
+		}
+		tokens = append(tokens, out[:idx+1])
+		out = out[idx+1:]
+	}
+	return tokens
+}
+
+// indexEcho 查找命令的回显, 回显之后应该是换行或结束
+func indexEcho(in, cmd string) int {
+	offset := 0
+	for {
+		idx := strings.Index(in[offset:], cmd)
+		if idx < 0 {
+			return -1
+		}
+		idx += offset
+		end := idx + len(cmd)
+		if end == len(in) || in[end] == '\r' || in[end] == '\n' {
+			return idx
+		}
+		offset = idx + 1
+	}
+}
+
+// indexQuestionEnd 查找第一个提问, 提示符或分页提示的结束位置, 找不到时返回 len(in)
+func indexQuestionEnd(in string) int {
+	end := len(in)
+	if idx := strings.IndexAny(in, ":>#$"); idx >= 0 {
+		end = idx + 1
+	}
+
+	// 分页提示有互相包含的, 如 "- more -" 和 "-- more --", 取最先出现的最长的那个
+	moreStart, moreEnd := -1, -1
+	for _, more := range shell.MorePrompts {
+		idx := bytes.Index([]byte(in), more)
+		if idx < 0 {
+			continue
+		}
+		if moreStart < 0 || idx < moreStart || (idx == moreStart && idx+len(more) > moreEnd) {
+			moreStart, moreEnd = idx, idx+len(more)
+		}
+	}
+	if moreStart >= 0 && moreStart < end {
+		end = moreEnd
+	}
+	return end
+}
@@ -0,0 +1,127 @@
+package harness
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mei-rune/shell"
+)
+
+func TestScriptReplayResults(t *testing.T) {
+	for _, test := range []struct {
+		testname   string
+		scriptText string
+		expected   []ExecuteResult
+	}{
+		{
+			testname: "test auto",
+			scriptText: `
+			@trigger "abc? [Y/N]:" {
+				@write N\r\n
+			}
+			@connect auto
+			@exec echo abcd
+			`,
+			expected: []ExecuteResult{
+				{LineNumber: 2, LineText: "@trigger \"abc? [Y/N]:\" {"},
+				{LineNumber: 5, LineText: "@connect auto",
+					Incomming: "abc? [Y/N]:ABC>enable\r\npassword:\r\nenable OK\r\nabc#",
+					Outgoing:  "N\r\nenable\r\n********\r\n",
+				},
+				{
+					LineNumber: 6,
+					LineText:   "@exec echo abcd",
+					Command:    "echo abcd",
+					Incomming:  "echo abcd\r\nprint abcd\r\nabc#",
+					Outgoing:   "echo abcd\r\n",
+				},
+			},
+		},
+		{
+			testname: "test more",
+			scriptText: `
+			@trigger "abc? [Y/N]:" {
+				@write N\r\n
+			}
+			@connect skipenable
+			@send <<enable>>
+			@echo password:
+			@send <<enable_password>>
+			@prompt
+			@exec show
+	  		`,
+			expected: []ExecuteResult{
+				{LineNumber: 2, LineText: "@trigger \"abc? [Y/N]:\" {"},
+				{LineNumber: 5, LineText: "@connect skipenable", Incomming: "abc? [Y/N]:ABC>", Outgoing: "N\r\n"},
+				{LineNumber: 6, LineText: "@send <<enable>>", Incomming: "", Outgoing: "enable\r\n"},
+				{LineNumber: 7, LineText: "@echo password:", Incomming: "enable\r\npassword:"},
+				{LineNumber: 8, LineText: "@send <<enable_password>>", Incomming: "", Outgoing: "********\r\n"},
+				{LineNumber: 9, LineText: "@prompt", Incomming: "\r\nenable OK\r\nabc#"},
+				{LineNumber: 10, LineText: "@exec show", Command: "show", Incomming: "show\r\nshow\r\nabcd\r\n-- more --efgh\r\n-- more --ijklmn\r\nabc#", Outgoing: "show\r\n  "},
+			},
+		},
+	} {
+		t.Run(test.testname, func(t *testing.T) {
+			script, err := ParseScript(strings.NewReader(test.scriptText))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			params := &SSHParam{
+				Address:        "127.0.0.1",
+				Username:       "abc",
+				Password:       "123",
+				EnableCommand:  "enable",
+				EnablePassword: "testsx",
+				UseCRLF:        true,
+				ReadTimeout:    1 * time.Second,
+			}
+
+			conn := shell.NewReplayConn(ResultsTranscript(test.expected))
+			sh := &Shell{SSHParams: params}
+			sh.WithOptions(WithConn(conn))
+
+			results, err := script.Run(context.Background(), sh)
+			if err != nil {
+				t.Error(err)
+			}
+			if !cmp.Equal(results, test.expected) {
+				t.Error(cmp.Diff(results, test.expected))
+			}
+			if err := conn.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestScriptReplayMismatch(t *testing.T) {
+	script, err := ParseScript(strings.NewReader(`
+			@connect skipenable
+			@exec echo abcd
+			`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var transcript shell.Transcript
+	transcript.Incoming("ABC>")
+	transcript.Outgoing("echo 1234\r\n")
+	transcript.Incoming("echo 1234\r\nprint 1234\r\nABC>")
+
+	conn := shell.NewReplayConn(transcript)
+	sh := &Shell{SSHParams: &SSHParam{UseCRLF: true, ReadTimeout: 1 * time.Second}}
+	sh.WithOptions(WithConn(conn))
+
+	_, err = script.Run(context.Background(), sh)
+	if err == nil {
+		t.Error("want error got ok")
+	} else if !strings.Contains(err.Error(), shell.ErrUnexpectedOutgoing.Error()) {
+		t.Error(err)
+	}
+}
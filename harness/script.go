@@ -44,6 +44,11 @@ func (se *scriptResult) Error() string {
 func (self *Script) Run(ctx context.Context, conn *Shell) ([]ExecuteResult, error) {
 	var results = make([]ExecuteResult, 0, len(self.Cmds))
 	for _, cmd := range self.Cmds {
+		if ctx != nil {
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+		}
 
 		var in strings.Builder
 		var out strings.Builder
@@ -2,13 +2,14 @@ package harness
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
-	// "github.com/mei-rune/shell/sim/sshd"
-	// "github.com/mei-rune/shell/sim/telnetd"
-	"tech.hengwei.com.cn/go/private/sim/sshd"
-	"tech.hengwei.com.cn/go/private/sim/telnetd"
+	"github.com/mei-rune/shell"
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
 
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/text/encoding/simplifiedchinese"
@@ -219,6 +220,7 @@ func TestScriptSimpleSSH(t *testing.T) {
 				}
 
 				params.UseExternalSSH = true
+				skipIfNoPlink(t)
 				conn := &Shell{SSHParams: params}
 				results, err := script.Run(ctx, conn)
 				if err != nil {
@@ -670,6 +672,7 @@ func TestScriptSimpleSSHMore(t *testing.T) {
 				}
 
 				params.UseExternalSSH = true
+				skipIfNoPlink(t)
 				conn := &Shell{SSHParams: params}
 				results, err := script.Run(ctx, conn)
 				if err != nil {
@@ -823,6 +826,7 @@ func TestScriptTelnetWithGb18030(t *testing.T) {
 				}
 
 				params.UseExternalSSH = true
+				skipIfNoPlink(t)
 				conn := &Shell{SSHParams: params}
 				results, err := script.Run(ctx, conn)
 				if err != nil {
@@ -851,3 +855,207 @@ func TestScriptTelnetWithGb18030(t *testing.T) {
 		})
 	}
 }
+
+func TestScriptCancel(t *testing.T) {
+	t.Run("sleep", func(t *testing.T) {
+		script, err := ParseScript(strings.NewReader(`
+			@sleep 10s
+			`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err = script.Run(ctx, &Shell{})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("want %v got %v", context.DeadlineExceeded, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("cancel is too slow, %s", elapsed)
+		}
+	})
+
+	t.Run("exec", func(t *testing.T) {
+		script, err := ParseScript(strings.NewReader(`
+			@connect skipenable
+			@exec show
+			`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		// 设备执行 show 后一直不返回提示符
+		options := &telnetd.Options{}
+		options.AddUserPassword("abc", "123")
+		options.WithNoEnable("ABC>", telnetd.OS(telnetd.Commands{
+			"show": func(s *telnetd.Session, line, args []byte) error {
+				for {
+					if _, err := s.ReadKey(); err != nil {
+						return err
+					}
+				}
+			},
+		}))
+
+		listener, err := telnetd.StartServer(":", options)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer listener.Close()
+
+		sh := &Shell{TelnetParams: &TelnetParam{
+			Address:     "127.0.0.1",
+			Port:        listener.Port(),
+			Username:    "abc",
+			Password:    "123",
+			UseCRLF:     true,
+			ReadTimeout: 30 * time.Second,
+		}}
+		defer sh.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(3*time.Second, cancel)
+
+		start := time.Now()
+		results, err := script.Run(ctx, sh)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("want %v got %v", context.Canceled, err)
+		}
+		if elapsed := time.Since(start); elapsed > 6*time.Second {
+			t.Errorf("cancel is too slow, %s", elapsed)
+		}
+		if len(results) != 2 {
+			t.Errorf("want 2 results got %d", len(results))
+		}
+	})
+}
+
+func TestScriptRegexp(t *testing.T) {
+	script, err := ParseScript(strings.NewReader(`
+			@trigger re"code ([0-9]+) *:" {
+				@send <<$1>>
+			}
+			@connect auto
+			@send configure
+			@prompt re"^\S+\(config[^)]*\)#\s*$"
+			@exec echo abcd
+			@send echo efgh
+			@echo re"print (\S+)\s"
+			`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithQuest("Enter code 4721 :", "4721", "Switch-3#",
+		telnetd.WithSystemView("configure", "", "Switch-3(config)#", telnetd.Echo))
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+
+	sh := &Shell{TelnetParams: &TelnetParam{
+		Address:  "127.0.0.1",
+		Port:     listener.Port(),
+		Username: "abc",
+		Password: "123",
+		UseCRLF:  true,
+	}}
+	defer sh.Close()
+
+	results, err := script.Run(context.Background(), sh)
+	if err != nil {
+		t.Error(err)
+		for _, result := range results {
+			t.Logf("%d %q %q", result.LineNumber, result.Incomming, result.Outgoing)
+		}
+		return
+	}
+
+	if string(sh.Prompt) != "Switch-3(config)#" {
+		t.Errorf("want 'Switch-3(config)#' got %q", sh.Prompt)
+	}
+	if !strings.Contains(results[len(results)-3].Incomming, "print abcd") {
+		t.Errorf("want 'print abcd' got %q", results[len(results)-3].Incomming)
+	}
+	if len(sh.Submatches) != 2 || string(sh.Submatches[1]) != "efgh" {
+		t.Errorf("want 'efgh' got %q", sh.Submatches)
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	// 设备执行 show 后一直分页显示, 永远不返回提示符
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.OS(telnetd.Commands{
+		"show": func(s *telnetd.Session, line, args []byte) error {
+			for {
+				time.Sleep(20 * time.Millisecond)
+				if _, err := s.Write([]byte("interface GigabitEthernet0/1\r\n --More--")); err != nil {
+					return err
+				}
+				if _, err := s.ReadKey(); err != nil {
+					return err
+				}
+			}
+		},
+	}))
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+
+	for _, test := range []struct {
+		directive string
+		kind      shell.TimeoutKind
+	}{
+		{directive: "@@expect_timeout 3s", kind: shell.ExpectTimeout},
+		{directive: "@@total_timeout 4s", kind: shell.DeadlineTimeout},
+	} {
+		t.Run(test.directive, func(t *testing.T) {
+			script, err := ParseScript(strings.NewReader(test.directive + `
+			@connect skipenable
+			@exec show
+			`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			sh := &Shell{TelnetParams: &TelnetParam{
+				Address:  "127.0.0.1",
+				Port:     listener.Port(),
+				Username: "abc",
+				Password: "123",
+				UseCRLF:  true,
+			}}
+			defer sh.Close()
+
+			start := time.Now()
+			results, err := script.Run(context.Background(), sh)
+			if !shell.IsTimeoutKind(err, test.kind) {
+				t.Errorf("want %s got %v", test.kind, err)
+			}
+			if elapsed := time.Since(start); elapsed > 8*time.Second {
+				t.Errorf("timeout is too slow, %s", elapsed)
+			}
+			if len(results) != 3 {
+				t.Errorf("want 3 results got %d", len(results))
+			}
+		})
+	}
+}
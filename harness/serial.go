@@ -4,10 +4,13 @@ import (
 	"context"
 	"io"
 	"net"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/mei-rune/shell"
+	"github.com/runner-mei/errors"
 	"github.com/tarm/serial"
 )
 
@@ -24,6 +27,22 @@ type SerialParam struct {
 	EnablePassword      string `json:"enable_password,omitempty" xml:"enable_password,omitempty" form:"enable_password,omitempty" query:"serial.enable_password,omitempty"`
 	EnablePrompt        string `json:"enable_prompt,omitempty" xml:"enable_prompt,omitempty" form:"enable_prompt,omitempty" query:"serial.enable_prompt,omitempty"`
 	UseCRLF             bool   `json:"use_crlf,omitempty" xml:"use_crlf,omitempty" form:"use_crlf,omitempty" query:"serial.use_crlf,omitempty"`
+
+	// ReadTimeout 为空闲超时, 即多长时间没有收到任何数据
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// ExpectTimeout 为每次等待提示符(包括其中自动回答问题)的超时, 0 表示不限制
+	ExpectTimeout time.Duration
+	// TotalTimeout 为从连接开始整个操作的超时, 0 表示不限制
+	TotalTimeout time.Duration
+
+	// Vendor 为设备的厂商, 用于选择厂商的超时策略(见 shell.RegisterVendorTimeoutPolicy)
+	Vendor string `json:"vendor,omitempty" xml:"vendor,omitempty" form:"vendor,omitempty" query:"serial.vendor,omitempty"`
+	// TimeoutPolicy 为空闲超时后的处理策略, 可以为 none, newline, space, ctrl-c 或 space-newline,
+	// 为空时使用厂商的策略, 厂商也没有时使用 shell.DefaultTimeoutPolicy
+	TimeoutPolicy string `json:"timeout_policy,omitempty" xml:"timeout_policy,omitempty" form:"timeout_policy,omitempty" query:"serial.timeout_policy,omitempty"`
+	// TimeoutRetries 为 TimeoutPolicy 最多重试的次数, 0 表示不限制
+	TimeoutRetries int `json:"timeout_retries,omitempty" xml:"timeout_retries,omitempty" form:"timeout_retries,omitempty" query:"serial.timeout_retries,omitempty"`
 }
 
 func DailSerial(ctx context.Context, params *SerialParam, args ...Option) (shell.Conn, []byte, error) {
@@ -34,9 +53,7 @@ func DailSerial(ctx context.Context, params *SerialParam, args ...Option) (shell
 	if opts.questions == nil {
 		opts.questions = noQuestions
 	}
-
-	cfg := &serial.Config{Name: params.Port, Baud: params.BaudRate, ReadTimeout: 5 * time.Second}
-	serialConn, err := serial.OpenPort(cfg)
+	timeoutPolicy, err := selectTimeoutPolicy(params.Vendor, params.TimeoutPolicy, params.TimeoutRetries, opts.timeoutPolicy)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -69,12 +86,29 @@ func DailSerial(ctx context.Context, params *SerialParam, args ...Option) (shell
 		}
 	}
 
-	c := shell.TelnetWrap(shell.NewTelnet(wrapSerial(serialConn)), opts.sWriter, opts.cWriter)
+	var c shell.Conn
+	if opts.conn != nil {
+		c = opts.conn
+	} else {
+		cfg := &serial.Config{Name: params.Port, Baud: params.BaudRate, ReadTimeout: 5 * time.Second}
+		serialConn, err := serial.OpenPort(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		c = shell.TelnetWrap(shell.NewTelnet(wrapSerial(serialConn, cfg)), opts.sWriter, opts.cWriter)
+	}
 	if params.UseCRLF {
 		c.UseCRLF()
 	}
-	c.SetReadDeadline(30 * time.Second)
-	c.SetWriteDeadline(10 * time.Second)
+	readTimeout := params.ReadTimeout
+	if readTimeout <= 0 {
+		readTimeout = DefaultReadTimeout
+	}
+	writeTimeout := params.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = DefaultWriteTimeout
+	}
+	setTimeouts(c, readTimeout, writeTimeout, params.ExpectTimeout, params.TotalTimeout, timeoutPolicy)
 
 	if opts.skipLogin {
 		return c, nil, nil
@@ -87,8 +121,7 @@ func DailSerial(ctx context.Context, params *SerialParam, args ...Option) (shell
 		c2()
 	}()
 
-	_, err = c.Write([]byte("\n"))
-	if err != nil {
+	if _, err := c.Write([]byte("\n")); err != nil {
 		return nil, nil, err
 	}
 
@@ -106,12 +139,130 @@ func DailSerial(ctx context.Context, params *SerialParam, args ...Option) (shell
 	}, &opts)
 }
 
-func wrapSerial(port *serial.Port) net.Conn {
-	return WrapPort{port}
+func init() {
+	shell.RegisterDialer("serial", dialSerialURL)
+}
+
+// dialSerialURL 打开 serial:///dev/ttyUSB0?baud=9600&parity=N&databits=8&stopbits=1
+// 或 serial://COM3?baud=9600 中的串口, 返回的连接还没有登录
+func dialSerialURL(ctx context.Context, u *url.URL) (shell.Conn, error) {
+	cfg, err := serialConfigFromURL(u)
+	if err != nil {
+		return nil, err
+	}
+	serialConn, err := serial.OpenPort(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return shell.TelnetWrap(shell.NewTelnet(wrapSerial(serialConn, cfg)), nil, nil), nil
+}
+
+func serialConfigFromURL(u *url.URL) (*serial.Config, error) {
+	name := u.Path
+	if name == "" {
+		name = u.Host
+	}
+	if name == "" {
+		name = u.Opaque
+	}
+	if name == "" {
+		return nil, errors.New("serial port is missing in '" + u.String() + "'")
+	}
+
+	query := u.Query()
+	cfg := &serial.Config{Name: name, Baud: 9600, ReadTimeout: 5 * time.Second}
+	baud, err := shell.QueryInt(query, "baud")
+	if err != nil {
+		return nil, err
+	}
+	if baud > 0 {
+		cfg.Baud = baud
+	}
+	dataBits, err := shell.QueryInt(query, "databits")
+	if err != nil {
+		return nil, err
+	}
+	if query.Get("databits") != "" && (dataBits < 5 || dataBits > 8) {
+		return nil, errors.New("'databits' is invalid - " + query.Get("databits"))
+	}
+	cfg.Size = byte(dataBits)
+
+	switch parity := strings.ToUpper(query.Get("parity")); parity {
+	case "", "N":
+		cfg.Parity = serial.ParityNone
+	case "O", "E", "M", "S":
+		cfg.Parity = serial.Parity(parity[0])
+	default:
+		return nil, errors.New("'parity' is invalid - " + parity)
+	}
+
+	switch stopBits := query.Get("stopbits"); stopBits {
+	case "", "1":
+		cfg.StopBits = serial.Stop1
+	case "1.5":
+		cfg.StopBits = serial.Stop1Half
+	case "2":
+		cfg.StopBits = serial.Stop2
+	default:
+		return nil, errors.New("'stopbits' is invalid - " + stopBits)
+	}
+	return cfg, nil
+}
+
+func wrapSerial(port *serial.Port, cfg *serial.Config) net.Conn {
+	return WrapPort{Port: port, Name: cfg.Name, Config: cfg}
 }
 
 type WrapPort struct {
 	*serial.Port
+
+	// Name 为串口的设备名, 发送 break 时使用
+	Name string
+	// Config 为打开串口时的设置, 用于 Metadata
+	Config *serial.Config
+}
+
+// Metadata 返回串口的设置
+func (wp WrapPort) Metadata() *shell.Metadata {
+	meta := &shell.SerialMetadata{
+		Port:     wp.Name,
+		DataBits: 8,
+		Parity:   "N",
+		StopBits: "1",
+	}
+	if wp.Config != nil {
+		meta.BaudRate = wp.Config.Baud
+		if wp.Config.Size != 0 {
+			meta.DataBits = int(wp.Config.Size)
+		}
+		if wp.Config.Parity != 0 {
+			meta.Parity = string(rune(wp.Config.Parity))
+		}
+		switch wp.Config.StopBits {
+		case serial.Stop1Half:
+			meta.StopBits = "1.5"
+		case serial.Stop2:
+			meta.StopBits = "2"
+		}
+	}
+	return &shell.Metadata{Transport: "serial", RemoteAddr: wp.Name, Serial: meta}
+}
+
+// SendSignal 串口没有带外信号, 在数据流中发送 Ctrl-C
+func (wp WrapPort) SendSignal(sig shell.Signal) error {
+	if sig != shell.SignalInterrupt {
+		return shell.ErrSignalUnsupported
+	}
+	_, err := wp.Port.Write([]byte{3})
+	return err
+}
+
+// SendBreak 在串口线路上发送 break
+func (wp WrapPort) SendBreak() error {
+	if wp.Name == "" {
+		return shell.ErrBreakUnsupported
+	}
+	return sendSerialBreak(wp.Name)
 }
 
 func (wp WrapPort) LocalAddr() net.Addr {
@@ -0,0 +1,31 @@
+package harness
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sendSerialBreak 用 TCSBRK 在串口线路上发送 0.25 到 0.5 秒的 break.
+// tarm/serial 没有提供它的文件句柄, 所以这里重新打开一次设备, ioctl 对同一个设备上的所有句柄都有效
+func sendSerialBreak(name string) error {
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rawConn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ioctlErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetInt(int(fd), unix.TCSBRK, 0)
+	})
+	if err != nil {
+		return err
+	}
+	return ioctlErr
+}
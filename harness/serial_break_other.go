@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package harness
+
+import "github.com/mei-rune/shell"
+
+func sendSerialBreak(name string) error {
+	return shell.ErrBreakUnsupported
+}
@@ -0,0 +1,299 @@
+package harness
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mei-rune/shell"
+	"github.com/mei-rune/shell/sim/seriald"
+)
+
+func startSerialSim(t *testing.T, options *seriald.Options) *seriald.Server {
+	srv, err := seriald.StartServer(options)
+	if err != nil {
+		t.Skip("create pty fail,", err)
+	}
+	return srv
+}
+
+func TestSerialSimSimple(t *testing.T) {
+	options := &seriald.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", seriald.Echo)
+
+	srv := startSerialSim(t, options)
+	defer srv.Close()
+
+	params := &SerialParam{
+		Port:     srv.Port(),
+		BaudRate: 9600,
+		Username: "abc",
+		Password: "123",
+		UseCRLF:  true,
+	}
+	testSerial(t, context.Background(), params)
+}
+
+func TestSerialSimWithEnablePassword(t *testing.T) {
+	options := &seriald.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithEnable("ABC>", "enable", "password:", "testsx", "", "abc#", seriald.Echo)
+
+	srv := startSerialSim(t, options)
+	defer srv.Close()
+
+	params := &SerialParam{
+		Port:           srv.Port(),
+		BaudRate:       115200,
+		Username:       "abc",
+		Password:       "123",
+		EnableCommand:  "enable",
+		EnablePassword: "testsx",
+		UseCRLF:        true,
+	}
+	testSerial(t, context.Background(), params)
+}
+
+func TestSerialSimMore(t *testing.T) {
+	options := &seriald.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithEnable("ABC>", "enable", "password:", "testsx", "", "abc#", seriald.OS(seriald.Commands{
+		"show": seriald.WithMore([]string{
+			"abcd",
+			"efgh",
+			"ijklmn",
+		}, []byte("-- more --"), nil),
+	}))
+
+	srv := startSerialSim(t, options)
+	defer srv.Close()
+
+	ctx := context.Background()
+	params := &SerialParam{
+		Port:           srv.Port(),
+		BaudRate:       9600,
+		Username:       "abc",
+		Password:       "123",
+		EnableCommand:  "enable",
+		EnablePassword: "testsx",
+		UseCRLF:        true,
+	}
+
+	c, prompt, err := DailSerial(ctx, params)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn := &Shell{Conn: c, Prompt: prompt}
+	defer conn.Close()
+
+	result, err := Exec(ctx, conn, "show")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, s := range []string{"abcd", "efgh", "ijklmn"} {
+		if !strings.Contains(result.Incomming, s) {
+			t.Errorf("want %q got %s", s, result.Incomming)
+		}
+	}
+}
+
+func TestScriptSimpleSerial(t *testing.T) {
+	script, err := ParseScript(strings.NewReader(`
+			@connect auto
+			@exec echo abcd
+	  		`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	options := &seriald.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithEnable("ABC>", "enable", "password:", "testsx", "", "abc#", seriald.Echo)
+
+	srv := startSerialSim(t, options)
+	defer srv.Close()
+
+	sh := &Shell{SerialParams: &SerialParam{
+		Port:           srv.Port(),
+		BaudRate:       9600,
+		Username:       "abc",
+		Password:       "123",
+		EnableCommand:  "enable",
+		EnablePassword: "testsx",
+		UseCRLF:        true,
+	}}
+	defer sh.Close()
+
+	results, err := script.Run(context.Background(), sh)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []ExecuteResult{
+		{
+			LineNumber: 2,
+			LineText:   "@connect auto",
+			Incomming:  "\r\nusername:password:ABC>enable\r\npassword:\r\nenable OK\r\nabc#",
+			Outgoing:   "\nabc\r\n********\r\nenable\r\n********\r\n",
+		},
+		{
+			LineNumber: 3,
+			LineText:   "@exec echo abcd",
+			Command:    "echo abcd",
+			Incomming:  "echo abcd\r\nprint abcd\r\nabc#",
+			Outgoing:   "echo abcd\r\n",
+		},
+	}
+	if !cmp.Equal(results, expected) {
+		t.Error(cmp.Diff(results, expected))
+	}
+}
+
+func TestSerialBreak(t *testing.T) {
+	options := &seriald.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", seriald.Echo)
+
+	srv := startSerialSim(t, options)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c, prompt, err := DailSerial(ctx, &SerialParam{
+		Port:     srv.Port(),
+		BaudRate: 9600,
+		Username: "abc",
+		Password: "123",
+		UseCRLF:  true,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn := &Shell{Conn: c, Prompt: prompt}
+	defer conn.Close()
+
+	err = c.SendBreak()
+	if runtime.GOOS == "linux" {
+		if err != nil {
+			t.Error(err)
+		}
+	} else if err != shell.ErrBreakUnsupported {
+		t.Errorf("want ErrBreakUnsupported got %v", err)
+	}
+
+	// 中断后仍然能继续执行命令
+	if err := conn.Interrupt(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+	result, err := Exec(ctx, conn, "echo abcd")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(result.Incomming, "print abcd") {
+		t.Errorf("want 'print abcd' got %s", result.Incomming)
+	}
+}
+
+func testSerial(t *testing.T, ctx context.Context, params *SerialParam) {
+	var buf bytes.Buffer
+	c, prompt, err := DailSerial(ctx, params, ServerWriter(&buf), ClientWriter(&buf))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	conn := &Shell{Conn: c, Prompt: prompt}
+	defer conn.Close()
+
+	result, err := Exec(ctx, conn, "echo abcd")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.Contains(result.Incomming, "print abcd") {
+		t.Errorf("want 'print abcd' got %s", result.Incomming)
+	}
+	t.Log(result.Incomming)
+	t.Log(buf.String())
+}
+
+func TestSerialMetadata(t *testing.T) {
+	options := &seriald.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", seriald.Echo)
+
+	srv := startSerialSim(t, options)
+	defer srv.Close()
+
+	c, _, err := DailSerial(context.Background(), &SerialParam{
+		Port:     srv.Port(),
+		BaudRate: 9600,
+	}, SkipLogin(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	md := c.Metadata()
+	if md == nil || md.Serial == nil {
+		t.Fatalf("metadata is missing, %#v", md)
+	}
+	excepted := shell.SerialMetadata{
+		Port:     srv.Port(),
+		BaudRate: 9600,
+		DataBits: 8,
+		Parity:   "N",
+		StopBits: "1",
+	}
+	if md.Transport != "serial" || *md.Serial != excepted {
+		t.Errorf("want %#v got %q %#v", excepted, md.Transport, md.Serial)
+	}
+}
+
+func TestDialSerialURL(t *testing.T) {
+	options := &seriald.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", seriald.Echo)
+
+	srv := startSerialSim(t, options)
+	defer srv.Close()
+
+	c, err := shell.Dial(context.Background(), "serial://"+srv.Port()+"?baud=19200&parity=e&stopbits=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	excepted := shell.SerialMetadata{
+		Port:     srv.Port(),
+		BaudRate: 19200,
+		DataBits: 8,
+		Parity:   "E",
+		StopBits: "2",
+	}
+	if md := c.Metadata(); md == nil || md.Serial == nil || *md.Serial != excepted {
+		t.Errorf("want %#v got %#v", excepted, md)
+	}
+
+	if _, err := shell.Dial(context.Background(), "serial:///dev/ttyS0?parity=x"); err == nil ||
+		!strings.Contains(err.Error(), "'parity' is invalid") {
+		t.Errorf("want parity error got %v", err)
+	}
+	for _, dataBits := range []string{"0", "4", "9", "264", "-1"} {
+		if _, err := shell.Dial(context.Background(), "serial:///dev/ttyS0?databits="+dataBits); err == nil ||
+			!strings.Contains(err.Error(), "'databits' is invalid") {
+			t.Errorf("%s: want databits error got %v", dataBits, err)
+		}
+	}
+}
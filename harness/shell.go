@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/mei-rune/shell"
 	"github.com/runner-mei/errors"
@@ -12,6 +14,9 @@ import (
 
 type DoFunc func(conn *Shell, idx int) (bool, error)
 
+// SubmatchFunc 为正则表达式匹配成功后的回调, submatches 为 FindSubmatch 的结果
+type SubmatchFunc func(conn *Shell, idx int, submatches [][]byte) (bool, error)
+
 type ExecuteResult struct {
 	LineNumber int    `json:"line_number"`
 	LineText   string `json:"line_text,omitempty"`
@@ -35,15 +40,74 @@ type Shell struct {
 	promptStack [][]byte
 	FailStrings [][]byte
 
+	// Submatches 为最近一次触发的正则表达式的子匹配, 在 @trigger 中可以用 <<$N>> 引用
+	Submatches [][]byte
+
 	userCRLF  bool
 	questions []shell.Matcher
 
+	idleTimeout   time.Duration
+	expectTimeout time.Duration
+	deadline      time.Time
+	timeoutPolicy shell.TimeoutPolicy
+
 	teeWriter io.Writer
 	teeReader io.Writer
 
 	opts []Option
 }
 
+// SetIdleTimeout 设置空闲超时, 还没有连接时在连接时使用
+func (s *Shell) SetIdleTimeout(timeout time.Duration) {
+	s.idleTimeout = timeout
+	if s.Conn != nil {
+		s.Conn.SetReadDeadline(timeout)
+	}
+}
+
+// SetExpectTimeout 设置每次等待提示符的超时, 还没有连接时在连接时使用
+func (s *Shell) SetExpectTimeout(timeout time.Duration) {
+	s.expectTimeout = timeout
+	if s.Conn != nil {
+		s.Conn.SetExpectTimeout(timeout)
+	}
+}
+
+// SetTotalTimeout 设置从现在开始整个操作的超时, 还没有连接时在连接时使用
+func (s *Shell) SetTotalTimeout(timeout time.Duration) {
+	s.deadline = time.Time{}
+	if timeout > 0 {
+		s.deadline = time.Now().Add(timeout)
+	}
+	if s.Conn != nil {
+		s.Conn.SetDeadline(s.deadline)
+	}
+}
+
+// SetTimeoutPolicy 设置空闲超时后的处理策略, 还没有连接时在连接时使用
+func (s *Shell) SetTimeoutPolicy(policy shell.TimeoutPolicy) {
+	s.timeoutPolicy = policy
+	if s.Conn != nil {
+		s.Conn.SetTimeoutPolicy(policy)
+	}
+}
+
+// applyTimeouts 用脚本中设置的超时覆盖连接参数中的超时
+func (s *Shell) applyTimeouts(read, expect, total *time.Duration) {
+	if s.idleTimeout > 0 {
+		*read = s.idleTimeout
+	}
+	if s.expectTimeout > 0 {
+		*expect = s.expectTimeout
+	}
+	if !s.deadline.IsZero() {
+		*total = time.Until(s.deadline)
+		if *total <= 0 {
+			*total = time.Nanosecond
+		}
+	}
+}
+
 func (s *Shell) WithOptions(opts ...Option) {
 	s.opts = append(s.opts, opts...)
 }
@@ -80,6 +144,19 @@ func (s *Shell) On(question interface{}, answer DoFunc) {
 	s.questions = append(s.questions, shell.Match(question, cb))
 }
 
+// OnSubmatch 和 On 一样, 但 question 为 *regexp.Regexp 或 []*regexp.Regexp, 并且回调中可以得到子匹配
+func (s *Shell) OnSubmatch(question interface{}, answer SubmatchFunc) {
+	cb := shell.SubmatchFunc(func(conn shell.Conn, bs []byte, idx int, submatches [][]byte) (bool, error) {
+		if s.Conn == nil {
+			s.Conn = conn // 可能是正在连接中
+		}
+		s.Submatches = submatches
+		return answer(s, idx, submatches)
+	})
+
+	s.questions = append(s.questions, shell.MatchRegexp(question, cb))
+}
+
 func (s *Shell) OnFail(question string) {
 	s.questions = append(s.questions, shell.Match(question, func(conn shell.Conn, bs []byte, idx int) (bool, error) {
 		return false, errors.New("收到错误消息: " + question)
@@ -143,6 +220,9 @@ func (s *Shell) Connect(ctx context.Context, target string, opts ...Option) erro
 	if len(s.opts) > 0 {
 		opts = append(opts, s.opts...)
 	}
+	if s.timeoutPolicy != nil {
+		opts = append(opts, OnTimeout(s.timeoutPolicy))
+	}
 	if s.teeWriter != nil {
 		opts = append(opts, Outgoing(s.teeWriter))
 	}
@@ -176,6 +256,7 @@ func (s *Shell) connectSerial(ctx context.Context, opts ...Option) error {
 	if s.userCRLF {
 		s.SerialParams.UseCRLF = true
 	}
+	s.applyTimeouts(&s.SerialParams.ReadTimeout, &s.SerialParams.ExpectTimeout, &s.SerialParams.TotalTimeout)
 
 	conn, prompt, err := DailSerial(ctx, s.SerialParams, opts...)
 	if err != nil {
@@ -191,6 +272,7 @@ func (s *Shell) connectTelnet(ctx context.Context, opts ...Option) error {
 	if s.userCRLF {
 		s.TelnetParams.UseCRLF = true
 	}
+	s.applyTimeouts(&s.TelnetParams.ReadTimeout, &s.TelnetParams.ExpectTimeout, &s.TelnetParams.TotalTimeout)
 
 	conn, prompt, err := DailTelnet(ctx, s.TelnetParams, opts...)
 	if err != nil {
@@ -206,6 +288,7 @@ func (s *Shell) connectSSH(ctx context.Context, opts ...Option) error {
 	if s.userCRLF {
 		s.SSHParams.UseCRLF = true
 	}
+	s.applyTimeouts(&s.SSHParams.ReadTimeout, &s.SSHParams.ExpectTimeout, &s.SSHParams.TotalTimeout)
 	conn, prompt, err := DailSSH(ctx, s.SSHParams, opts...)
 	if err != nil {
 		return err
@@ -295,6 +378,15 @@ func (s *Shell) ReadPrompt(ctx context.Context, expected [][]byte) error {
 	return nil
 }
 
+func (s *Shell) ReadPromptRegexp(ctx context.Context, expected *regexp.Regexp) error {
+	prompt, err := shell.ReadPromptRegexp(ctx, s.Conn, expected, s.questions...)
+	if err != nil {
+		return err
+	}
+	s.SetPrompt(prompt)
+	return nil
+}
+
 func (s *Shell) WithView(ctx context.Context, cmd []byte, newPrompts [][]byte) error {
 	newPrompt, err := shell.WithView(ctx, s.Conn, cmd, newPrompts)
 	if err != nil {
@@ -333,6 +425,14 @@ func (s *Shell) RunScript(ctx context.Context, subScript *Script) ([]ExecuteResu
 	return subScript.Run(ctx, s)
 }
 
+// Interrupt 中断正在执行的命令, 并等待 s.Prompt 重新出现
+func (s *Shell) Interrupt(ctx context.Context) error {
+	if s.Conn == nil {
+		return errors.New("无连接")
+	}
+	return shell.Interrupt(ctx, s.Conn, s.Prompt)
+}
+
 func (s *Shell) Exec(ctx context.Context, command string) error {
 	return s.exec(ctx, []byte(command))
 }
@@ -404,6 +504,11 @@ type options struct {
 	skipEnable bool
 	questions  []shell.Matcher
 
+	// conn 不为 nil 时直接使用它而不是建立新的连接, 用于回放会话记录
+	conn shell.Conn
+
+	timeoutPolicy shell.TimeoutPolicy
+
 	// UserQuest           string
 	// PasswordQuest       string
 	// Prompt              string
@@ -461,6 +566,21 @@ func Outgoing(w io.Writer) Option {
 	})
 }
 
+// WithConn 使用已有的连接(如 shell.NewReplayConn 创建的回放连接)代替
+// DailSSH, DailTelnet 和 DailSerial 中新建的连接
+func WithConn(conn shell.Conn) Option {
+	return optionFunc(func(o *options) {
+		o.conn = conn
+	})
+}
+
+// OnTimeout 指定空闲超时后的处理策略, 优先于参数中的 TimeoutPolicy 和 Vendor
+func OnTimeout(policy shell.TimeoutPolicy) Option {
+	return optionFunc(func(o *options) {
+		o.timeoutPolicy = policy
+	})
+}
+
 func SkipLogin(skip bool) Option {
 	return optionFunc(func(o *options) {
 		o.skipLogin = skip
@@ -68,6 +68,28 @@ func readQuoteString(txt []rune, isDq bool) ([]rune, []rune, error) {
 	return word, nil, errors.New("Expected a `\"` (double quote)")
 }
 
+// readRawQuoteString 读取正则表达式等不需要转义的字符串, 只有 \" 会被转换为 "
+func readRawQuoteString(txt []rune) ([]rune, []rune, error) {
+	var word []rune
+	for idx := 0; idx < len(txt); idx++ {
+		c := txt[idx]
+		switch c {
+		case '\\':
+			if idx+1 < len(txt) && txt[idx+1] == '"' {
+				word = append(word, '"')
+				idx++
+				continue
+			}
+			word = append(word, c)
+		case '"':
+			return word, txt[idx+1:], nil
+		default:
+			word = append(word, c)
+		}
+	}
+	return word, nil, errors.New("Expected a `\"` (double quote)")
+}
+
 func readIdentString(txt []rune) (string, []rune, []rune, error) {
 	for idx, c := range txt {
 		if unicode.IsSpace(c) {
@@ -77,6 +99,13 @@ func readIdentString(txt []rune) (string, []rune, []rune, error) {
 		switch c {
 		case '\'':
 		case '"':
+			if string(txt[:idx]) == "re" {
+				word, line, err := readRawQuoteString(txt[idx+1:])
+				if err != nil {
+					return "", nil, nil, err
+				}
+				return string(txt[:idx]), word, line, nil
+			}
 			word, line, err := readQuoteString(txt[idx+1:], true)
 			if err != nil {
 				return "", nil, nil, err
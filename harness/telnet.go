@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/mei-rune/shell"
@@ -24,8 +25,58 @@ type TelnetParam struct {
 	EnablePrompt        string `json:"enable_prompt,omitempty" xml:"enable_prompt,omitempty" form:"enable_prompt,omitempty" query:"telnet.enable_prompt,omitempty"`
 	UseCRLF             bool   `json:"use_crlf,omitempty" xml:"use_crlf,omitempty" form:"use_crlf,omitempty" query:"telnet.use_crlf,omitempty"`
 
+	// ReadTimeout 为空闲超时, 即多长时间没有收到任何数据
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+	// ExpectTimeout 为每次等待提示符(包括其中自动回答问题)的超时, 0 表示不限制
+	ExpectTimeout time.Duration
+	// TotalTimeout 为从连接开始整个操作的超时, 0 表示不限制
+	TotalTimeout time.Duration
+
+	// KeepaliveInterval 为发送 keepalive 的间隔, 也是每个 keepalive 等待响应的超时, 0 表示不发送
+	KeepaliveInterval time.Duration
+	// KeepaliveMaxMissed 为 keepalive 最多连续没有响应的次数, 超过后断开连接, 0 表示使用缺省值
+	KeepaliveMaxMissed int `json:"keepalive_max_missed,omitempty" xml:"keepalive_max_missed,omitempty" form:"keepalive_max_missed,omitempty" query:"telnet.keepalive_max_missed,omitempty"`
+	// KeepaliveAYT 为 true 时发送 IAC AYT 并等待回答, 否则发送 IAC NOP
+	KeepaliveAYT bool `json:"keepalive_ayt,omitempty" xml:"keepalive_ayt,omitempty" form:"keepalive_ayt,omitempty" query:"telnet.keepalive_ayt,omitempty"`
+
+	// Vendor 为设备的厂商, 用于选择厂商的超时策略(见 shell.RegisterVendorTimeoutPolicy)
+	Vendor string `json:"vendor,omitempty" xml:"vendor,omitempty" form:"vendor,omitempty" query:"telnet.vendor,omitempty"`
+	// TimeoutPolicy 为空闲超时后的处理策略, 可以为 none, newline, space, ctrl-c 或 space-newline,
+	// 为空时使用厂商的策略, 厂商也没有时使用 shell.DefaultTimeoutPolicy
+	TimeoutPolicy string `json:"timeout_policy,omitempty" xml:"timeout_policy,omitempty" form:"timeout_policy,omitempty" query:"telnet.timeout_policy,omitempty"`
+	// TimeoutRetries 为 TimeoutPolicy 最多重试的次数, 0 表示不限制
+	TimeoutRetries int `json:"timeout_retries,omitempty" xml:"timeout_retries,omitempty" form:"timeout_retries,omitempty" query:"telnet.timeout_retries,omitempty"`
+
+	// Columns 和 Rows 为终端窗口的初始大小, 为 0 时使用缺省值, 连接后可以用 Conn.Resize 改变
+	Columns int `json:"columns,omitempty" xml:"columns,omitempty" form:"columns,omitempty" query:"telnet.columns,omitempty"`
+	Rows    int `json:"rows,omitempty" xml:"rows,omitempty" form:"rows,omitempty" query:"telnet.rows,omitempty"`
+	// TermType 为 TTYPE 协商时回答的终端类型, 多个时用逗号分隔, 对端多次询问时轮流回答, 为空时使用 XTERM
+	TermType string `json:"term_type,omitempty" xml:"term_type,omitempty" form:"term_type,omitempty" query:"telnet.term_type,omitempty"`
+
+	// JumpHosts 为按顺序经过的 ssh 跳板机, 最后一个跳板机通过 direct-tcpip 通道连接 telnet 服务
+	JumpHosts []SSHParam `json:"jump_hosts,omitempty" xml:"jump_hosts,omitempty" form:"-" query:"-"`
+	// Proxy 为代理, 格式和 SSHParam.Proxy 相同, 为空时使用环境变量 ALL_PROXY
+	Proxy string `json:"proxy,omitempty" xml:"proxy,omitempty" form:"proxy,omitempty" query:"telnet.proxy,omitempty"`
+}
+
+// proxyDialer 按代理的地址返回 shell.ContextDialer, 为空时返回 nil, 即由 shell 包使用环境变量 ALL_PROXY
+func proxyDialer(proxy string) (shell.ContextDialer, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	return shell.ParseProxy(proxy)
+}
+
+// splitList 将逗号分隔的字符串分开, 忽略空的项
+func splitList(s string) []string {
+	var list []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			list = append(list, t)
+		}
+	}
+	return list
 }
 
 func (param *TelnetParam) Host() string {
@@ -45,8 +96,7 @@ func DailTelnet(ctx context.Context, params *TelnetParam, args ...Option) (shell
 	if opts.questions == nil {
 		opts.questions = noQuestions
 	}
-
-	telnetConn, err := shell.DialTelnetTimeout("tcp", params.Host(), 30*time.Second)
+	timeoutPolicy, err := selectTimeoutPolicy(params.Vendor, params.TimeoutPolicy, params.TimeoutRetries, opts.timeoutPolicy)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -86,12 +136,40 @@ func DailTelnet(ctx context.Context, params *TelnetParam, args ...Option) (shell
 		params.WriteTimeout = DefaultWriteTimeout
 	}
 
-	c := shell.TelnetWrap(telnetConn, opts.sWriter, opts.cWriter)
+	var c shell.Conn
+	if opts.conn != nil {
+		c = opts.conn
+	} else {
+		jumpHosts, err := jumpHostsOf(params.JumpHosts)
+		if err != nil {
+			return nil, nil, err
+		}
+		dialer, err := proxyDialer(params.Proxy)
+		if err != nil {
+			return nil, nil, err
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		telnetConn, err := shell.DialTelnetWith(dialCtx, dialer, params.Host(), jumpHosts)
+		cancel()
+		if err != nil {
+			return nil, nil, err
+		}
+		if params.Columns > 0 && params.Rows > 0 {
+			if err := telnetConn.Resize(params.Columns, params.Rows); err != nil {
+				telnetConn.Close()
+				return nil, nil, err
+			}
+		}
+		if params.TermType != "" {
+			telnetConn.SetTermTypes(splitList(params.TermType)...)
+		}
+		telnetConn.SetKeepalive(params.KeepaliveInterval, params.KeepaliveMaxMissed, params.KeepaliveAYT)
+		c = shell.TelnetWrap(telnetConn, opts.sWriter, opts.cWriter)
+	}
 	if params.UseCRLF {
 		c.UseCRLF()
 	}
-	c.SetReadDeadline(params.ReadTimeout)
-	c.SetWriteDeadline(params.WriteTimeout)
+	setTimeouts(c, params.ReadTimeout, params.WriteTimeout, params.ExpectTimeout, params.TotalTimeout, timeoutPolicy)
 
 	if opts.skipLogin {
 		return c, nil, nil
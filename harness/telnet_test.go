@@ -3,11 +3,14 @@ package harness
 import (
 	"bytes"
 	"context"
+	"errors"
 	"strings"
 	"testing"
 
-	// "github.com/mei-rune/shell/sim/telnetd"
-	"tech.hengwei.com.cn/go/private/sim/telnetd"
+	"github.com/mei-rune/shell"
+	"github.com/mei-rune/shell/sim/proxyd"
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
 )
 
 func TestTelnetSimSimple(t *testing.T) {
@@ -386,3 +389,82 @@ func testTelnet(t *testing.T, ctx context.Context, params *TelnetParam) {
 	t.Log(result.Incomming)
 	t.Log(buf.String())
 }
+
+func TestTelnetSimJumpHost(t *testing.T) {
+	jumpOptions := &sshd.Options{AllowTCPForwarding: true}
+	jumpOptions.AddUserPassword("j1", "p1")
+	jumpOptions.WithNoEnable("JUMP>", sshd.Echo)
+	jump, err := sshd.StartServer(":", jumpOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jump.Close()
+
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.Echo)
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	params := &TelnetParam{
+		Address:  "127.0.0.1",
+		Port:     listener.Port(),
+		Username: "abc",
+		Password: "123",
+		UseCRLF:  true,
+		JumpHosts: []SSHParam{
+			{Address: "127.0.0.1", Port: jump.Port(), Username: "j1", Password: "p1"},
+		},
+	}
+	testTelnet(t, context.Background(), params)
+
+	params.JumpHosts[0].Password = "wrong"
+	if _, _, err := DailTelnet(context.Background(), params); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestTelnetSimProxy(t *testing.T) {
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.Echo)
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	proxy, err := proxyd.StartHTTPConnect(":", &proxyd.Options{
+		Username: "u1",
+		Password: "p1",
+		Hosts:    map[string]string{"device.invalid": "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	params := &TelnetParam{
+		Address:  "device.invalid",
+		Port:     listener.Port(),
+		Username: "abc",
+		Password: "123",
+		UseCRLF:  true,
+		Proxy:    "http://u1:p1@127.0.0.1:" + proxy.Port(),
+	}
+	testTelnet(t, context.Background(), params)
+
+	// 没有设置 Proxy 时使用 ALL_PROXY
+	params.Proxy = ""
+	t.Setenv("all_proxy", "")
+	t.Setenv("ALL_PROXY", "http://u1:p1@127.0.0.1:"+proxy.Port())
+	testTelnet(t, context.Background(), params)
+
+	params.Proxy = "http://127.0.0.1:" + proxy.Port()
+	if _, _, err := DailTelnet(context.Background(), params); !errors.Is(err, shell.ErrProxyAuth) {
+		t.Errorf("want auth failed got %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+package shell
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// 主机密钥的验证方式
+const (
+	// HostKeyInsecure 不验证主机密钥, 这是以前的行为, 也是缺省值
+	HostKeyInsecure = "insecure"
+	// HostKeyStrict 只接受 known_hosts 中已有的主机密钥
+	HostKeyStrict = "strict"
+	// HostKeyTOFU 第一次连接时把主机密钥记到 known_hosts 中(trust on first use), 以后必须相同
+	HostKeyTOFU = "tofu"
+)
+
+// ErrHostKeyMismatch 表示主机密钥和已知的不同, 可能是设备换了密钥或者有中间人,
+// 这时返回的错误为 *HostKeyError, 它满足 errors.Is(err, ErrHostKeyMismatch)
+var ErrHostKeyMismatch = errors.New("host key mismatch")
+
+// ErrHostKeyUnknown 表示 strict 方式下主机不在 known_hosts 中
+var ErrHostKeyUnknown = errors.New("host key is unknown")
+
+// HostKeyError 为主机密钥验证失败的错误, 包含已知的和服务端发来的指纹
+type HostKeyError struct {
+	Host string
+	// Want 为已知的(或固定的)指纹, 为空表示主机是未知的
+	Want []string
+	// Got 为服务端发来的主机密钥的指纹
+	Got string
+	// Source 为已知的指纹的来源, 如 known_hosts 的文件名和行号
+	Source string
+}
+
+func (e *HostKeyError) Error() string {
+	if len(e.Want) == 0 {
+		return "host key for '" + e.Host + "' is unknown, got " + e.Got
+	}
+	s := "host key mismatch for '" + e.Host + "', want " + strings.Join(e.Want, " or ") + " got " + e.Got
+	if e.Source != "" {
+		s += " (known at " + e.Source + ")"
+	}
+	return s
+}
+
+func (e *HostKeyError) Is(target error) bool {
+	if len(e.Want) == 0 {
+		return target == ErrHostKeyUnknown
+	}
+	return target == ErrHostKeyMismatch
+}
+
+// HostKeyPolicy 为主机密钥的验证策略
+type HostKeyPolicy struct {
+	// Mode 为 insecure, strict 或 tofu, 为空时如果有 Fingerprints 则只验证指纹, 否则为 insecure.
+	// 注意为了兼容以前的行为, 缺省是 insecure, 即不验证主机密钥(不能防止中间人攻击),
+	// 需要验证时必须明确指定 strict, tofu 或 Fingerprints
+	Mode string
+	// KnownHostsFiles 为 OpenSSH 格式的 known_hosts 文件, 为空时使用 DefaultKnownHostsFile(),
+	// tofu 方式时新的主机密钥追加到第一个文件中
+	KnownHostsFiles []string
+	// Fingerprints 为固定的主机密钥指纹, 格式为 SHA256:xxx 或 MD5:xx:xx:..., 不为空时只接受这些
+	// 指纹, 不再查看 known_hosts
+	Fingerprints []string
+	// CAKeys 为签发主机证书的 CA 的公钥(authorized_keys 格式, 每行一个), 不为空时接受这些 CA 签发的主机证书,
+	// 服务端发来的不是证书时按 Mode 和 Fingerprints 验证, 这时 Mode 为空表示拒绝
+	CAKeys []string
+}
+
+// DefaultKnownHostsFile 返回 ~/.ssh/known_hosts
+func DefaultKnownHostsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".ssh", "known_hosts")
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// Callback 按策略返回 ssh.HostKeyCallback, p 为 nil 时不验证主机密钥
+func (p *HostKeyPolicy) Callback() (ssh.HostKeyCallback, error) {
+	if p == nil {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if len(p.CAKeys) == 0 {
+		return p.keyCallback()
+	}
+	var fallback ssh.HostKeyCallback
+	if p.Mode != "" || len(p.Fingerprints) > 0 {
+		cb, err := p.keyCallback()
+		if err != nil {
+			return nil, err
+		}
+		fallback = cb
+	}
+	return CertificateHostKey(p.CAKeys, fallback)
+}
+
+func (p *HostKeyPolicy) keyCallback() (ssh.HostKeyCallback, error) {
+	if len(p.Fingerprints) > 0 {
+		return PinnedHostKey(p.Fingerprints...)
+	}
+
+	files := p.KnownHostsFiles
+	if len(files) == 0 {
+		files = []string{DefaultKnownHostsFile()}
+	}
+	switch strings.ToLower(p.Mode) {
+	case "", HostKeyInsecure:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyStrict:
+		return KnownHostsHostKey(files...)
+	case HostKeyTOFU:
+		return TOFUHostKey(files...)
+	default:
+		return nil, errors.New("host key policy '" + p.Mode + "' is unsupported")
+	}
+}
+
+// KnownHostsHostKey 只接受 known_hosts 文件中已有的主机密钥, 不存在的文件当作空文件
+func KnownHostsHostKey(files ...string) (ssh.HostKeyCallback, error) {
+	cb, err := loadKnownHosts(files)
+	if err != nil {
+		return nil, err
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return hostKeyError(hostname, key, checkKnownHosts(cb, hostname, remote, key))
+	}, nil
+}
+
+// checkKnownHosts 在 known_hosts 中检查主机密钥, 服务端发来的证书没有对应的 @cert-authority 时
+// 和 OpenSSH 一样再检查证书中的公钥
+func checkKnownHosts(cb ssh.HostKeyCallback, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	err := cb(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if cert, ok := key.(*ssh.Certificate); ok && !errors.As(err, &keyErr) {
+		return cb(hostname, remote, cert.Key)
+	}
+	return err
+}
+
+var tofuLock sync.Mutex
+
+// TOFUHostKey 第一次连接时把主机密钥追加到 files[0] 中, 以后必须和记住的相同.
+// 每次连接时都会重新读文件, 所以多个连接可以共用同一个文件
+func TOFUHostKey(files ...string) (ssh.HostKeyCallback, error) {
+	if len(files) == 0 {
+		return nil, errors.New("known_hosts file is missing")
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		tofuLock.Lock()
+		defer tofuLock.Unlock()
+
+		cb, err := loadKnownHosts(files)
+		if err != nil {
+			return err
+		}
+		if cert, ok := key.(*ssh.Certificate); ok && checkKnownHosts(cb, hostname, remote, key) != nil {
+			key = cert.Key
+		}
+		err = hostKeyError(hostname, key, cb(hostname, remote, key))
+		if !errors.Is(err, ErrHostKeyUnknown) {
+			return err
+		}
+		return appendKnownHost(files[0], hostname, key)
+	}, nil
+}
+
+func loadKnownHosts(files []string) (ssh.HostKeyCallback, error) {
+	var exists []string
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		exists = append(exists, file)
+	}
+	cb, err := knownhosts.New(exists...)
+	if err != nil {
+		return nil, errors.Wrap(err, "read known_hosts")
+	}
+	return cb, nil
+}
+
+func appendKnownHost(file, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// hostKeyError 将 knownhosts 的错误转换为 *HostKeyError
+func hostKeyError(hostname string, key ssh.PublicKey, err error) error {
+	if err == nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+	e := &HostKeyError{Host: hostname, Got: ssh.FingerprintSHA256(key)}
+	for _, known := range keyErr.Want {
+		e.Want = append(e.Want, ssh.FingerprintSHA256(known.Key))
+		if e.Source == "" {
+			e.Source = known.Filename + ":" + strconv.Itoa(known.Line)
+		}
+	}
+	return e
+}
+
+// PinnedHostKey 只接受指定指纹的主机密钥, 指纹的格式为 SHA256:xxx(和 ssh-keygen -l 的输出相同)
+// 或 MD5:xx:xx:...
+func PinnedHostKey(fingerprints ...string) (ssh.HostKeyCallback, error) {
+	var want []string
+	for _, fp := range fingerprints {
+		fp = strings.TrimSpace(fp)
+		if fp == "" {
+			continue
+		}
+		normalized, err := normalizeFingerprint(fp)
+		if err != nil {
+			return nil, err
+		}
+		want = append(want, normalized)
+	}
+	if len(want) == 0 {
+		return nil, errors.New("host key fingerprint is missing")
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if cert, ok := key.(*ssh.Certificate); ok {
+			key = cert.Key
+		}
+		sha256 := ssh.FingerprintSHA256(key)
+		md5 := "MD5:" + ssh.FingerprintLegacyMD5(key)
+		for _, fp := range want {
+			if fp == sha256 || fp == md5 {
+				return nil
+			}
+		}
+		return &HostKeyError{Host: hostname, Want: want, Got: sha256, Source: "pinned"}
+	}, nil
+}
+
+// CertificateHostKey 只接受 cas 签发的, principals 中有主机名的主机证书. 服务端发来的不是证书时
+// 使用 fallback 验证, fallback 为 nil 时拒绝. cas 为 authorized_keys 格式的公钥, 每行一个
+func CertificateHostKey(cas []string, fallback ssh.HostKeyCallback) (ssh.HostKeyCallback, error) {
+	var authorities [][]byte
+	for _, text := range cas {
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to parse CA key")
+			}
+			authorities = append(authorities, pub.Marshal())
+		}
+	}
+	if len(authorities) == 0 {
+		return nil, errors.New("CA key is missing")
+	}
+	if fallback == nil {
+		fallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return &HostKeyError{Host: hostname, Got: ssh.FingerprintSHA256(key)}
+		}
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, ca := range authorities {
+				if bytes.Equal(ca, auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: fallback,
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := checker.CheckHostKey(hostname, remote, key)
+		if _, ok := key.(*ssh.Certificate); ok && err != nil {
+			return errors.Wrap(err, "host certificate of '"+hostname+"' is invalid")
+		}
+		return err
+	}, nil
+}
+
+func normalizeFingerprint(fp string) (string, error) {
+	if strings.HasPrefix(fp, "SHA256:") {
+		hash := strings.TrimRight(strings.TrimPrefix(fp, "SHA256:"), "=")
+		if bs, err := base64.RawStdEncoding.DecodeString(hash); err != nil || len(bs) != 32 {
+			return "", errors.New("host key fingerprint '" + fp + "' is invalid")
+		}
+		return "SHA256:" + hash, nil
+	}
+
+	hash := strings.ToLower(fp)
+	if len(hash) > 4 && strings.EqualFold(fp[:4], "MD5:") {
+		hash = hash[4:]
+	}
+	if len(hash) != 47 {
+		return "", errors.New("host key fingerprint '" + fp + "' is invalid")
+	}
+	for i := 0; i < len(hash); i++ {
+		c := hash[i]
+		if i%3 == 2 {
+			if c != ':' {
+				return "", errors.New("host key fingerprint '" + fp + "' is invalid")
+			}
+		} else if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return "", errors.New("host key fingerprint '" + fp + "' is invalid")
+		}
+	}
+	return "MD5:" + hash, nil
+}
@@ -0,0 +1,156 @@
+package shell
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mei-rune/shell/sim/sshd"
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func connectWithPolicy(host string, policy *HostKeyPolicy) error {
+	cb, err := policy.Callback()
+	if err != nil {
+		return err
+	}
+	conn, err := ConnectSSHWithOptions(host, "abc", "123", "", &SSHOptions{HostKeyCallback: cb}, nil, nil)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestHostKeyTOFU(t *testing.T) {
+	oldKey, newKey := newHostKey(t), newHostKey(t)
+	listener, host := startSSHSim(t, simConfig{HostKey: oldKey})
+	defer listener.Close()
+
+	knownHosts := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	strict := &HostKeyPolicy{Mode: HostKeyStrict, KnownHostsFiles: []string{knownHosts}}
+	tofu := &HostKeyPolicy{Mode: HostKeyTOFU, KnownHostsFiles: []string{knownHosts}}
+
+	err := connectWithPolicy(host, strict)
+	if !errors.Is(err, ErrHostKeyUnknown) {
+		t.Fatalf("want unknown got %v", err)
+	}
+
+	// 第一次连接时记住
+	if err := connectWithPolicy(host, tofu); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := os.ReadFile(knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(bs)), "\n"); len(lines) != 1 ||
+		!strings.HasPrefix(lines[0], "[127.0.0.1]:"+listener.Port()+" ssh-ed25519 ") {
+		t.Fatalf("known_hosts is invalid, %q", bs)
+	}
+
+	// 以后 tofu 和 strict 都可以连接, 并且不会重复记录
+	if err := connectWithPolicy(host, tofu); err != nil {
+		t.Fatal(err)
+	}
+	if err := connectWithPolicy(host, strict); err != nil {
+		t.Fatal(err)
+	}
+	if bs2, _ := os.ReadFile(knownHosts); string(bs2) != string(bs) {
+		t.Fatalf("known_hosts is changed, %q", bs2)
+	}
+
+	// 换了密钥
+	listener.Close()
+	listener, err = sshd.StartServerWithHostKey(host, &sshd.Options{}, newKey)
+	if err != nil {
+		t.Skip("port is reused by other,", err)
+	}
+	defer listener.Close()
+
+	for _, policy := range []*HostKeyPolicy{tofu, strict} {
+		err = connectWithPolicy(host, policy)
+		if !errors.Is(err, ErrHostKeyMismatch) {
+			t.Fatalf("%s: want mismatch got %v", policy.Mode, err)
+		}
+		var hostKeyErr *HostKeyError
+		if !errors.As(err, &hostKeyErr) {
+			t.Fatalf("%s: want *HostKeyError got %T", policy.Mode, err)
+		}
+		oldFp, newFp := ssh.FingerprintSHA256(oldKey.PublicKey()), ssh.FingerprintSHA256(newKey.PublicKey())
+		if len(hostKeyErr.Want) != 1 || hostKeyErr.Want[0] != oldFp || hostKeyErr.Got != newFp {
+			t.Errorf("%s: fingerprints are invalid, %#v", policy.Mode, hostKeyErr)
+		}
+		if msg := err.Error(); !strings.Contains(msg, oldFp) || !strings.Contains(msg, newFp) || !strings.Contains(msg, knownHosts+":1") {
+			t.Errorf("%s: message is invalid, %s", policy.Mode, msg)
+		}
+	}
+}
+
+func TestHostKeyPinned(t *testing.T) {
+	signer := newHostKey(t)
+	listener, host := startSSHSim(t, simConfig{HostKey: signer})
+	defer listener.Close()
+
+	key := signer.PublicKey()
+	for _, fp := range []string{
+		ssh.FingerprintSHA256(key),
+		ssh.FingerprintSHA256(key) + "=",
+		"MD5:" + ssh.FingerprintLegacyMD5(key),
+		strings.ToUpper(ssh.FingerprintLegacyMD5(key)),
+	} {
+		if err := connectWithPolicy(host, &HostKeyPolicy{Fingerprints: []string{"SHA256:" + strings.Repeat("A", 43), fp}}); err != nil {
+			t.Errorf("%s: %v", fp, err)
+		}
+	}
+
+	other := ssh.FingerprintSHA256(newHostKey(t).PublicKey())
+	err := connectWithPolicy(host, &HostKeyPolicy{Mode: HostKeyTOFU, Fingerprints: []string{other}})
+	if !errors.Is(err, ErrHostKeyMismatch) {
+		t.Fatalf("want mismatch got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, other) || !strings.Contains(msg, ssh.FingerprintSHA256(key)) {
+		t.Errorf("message is invalid, %s", msg)
+	}
+
+	for _, fp := range []string{"SHA256:abc", "MD5:12:34", "xx"} {
+		if _, err := PinnedHostKey(fp); err == nil {
+			t.Errorf("%s: want error", fp)
+		}
+	}
+
+	// URL 中 + 没有转义时也可以
+	conn, err := Dial(context.Background(), "ssh://abc:123@"+host+"?fingerprint="+ssh.FingerprintSHA256(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	_, err = Dial(context.Background(), "ssh://abc:123@"+host+"?fingerprint="+url.QueryEscape(other))
+	if !errors.Is(err, ErrHostKeyMismatch) {
+		t.Fatalf("want mismatch got %v", err)
+	}
+}
+
+func TestHostKeyPolicyInvalid(t *testing.T) {
+	if _, err := (&HostKeyPolicy{Mode: "abc"}).Callback(); err == nil {
+		t.Error("want error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"regexp"
 	"strconv"
 	"time"
 	"unicode"
@@ -228,8 +229,72 @@ func (s *bytesMatcher) Do() DoFunc {
 	return s.do
 }
 
+// SubmatchFunc 为正则表达式匹配成功后的回调, submatches 为 FindSubmatch 的结果
+type SubmatchFunc func(conn Conn, bs []byte, idx int, submatches [][]byte) (bool, error)
+
+// RegexpMatcher 为用正则表达式匹配的 Matcher
+type RegexpMatcher interface {
+	Matcher
+	Regexps() []*regexp.Regexp
+	DoSubmatch() SubmatchFunc
+}
+
+type regexpMatcher struct {
+	regexps []*regexp.Regexp
+	do      SubmatchFunc
+}
+
+func (s *regexpMatcher) Strings() []string {
+	var prompts []string
+	for idx := range s.regexps {
+		prompts = append(prompts, s.regexps[idx].String())
+	}
+	return prompts
+}
+
+func (s *regexpMatcher) Prompts() [][]byte {
+	return nil
+}
+
+func (s *regexpMatcher) Regexps() []*regexp.Regexp {
+	return s.regexps
+}
+
+func (s *regexpMatcher) Do() DoFunc {
+	return func(conn Conn, bs []byte, idx int) (bool, error) {
+		return s.do(conn, bs, idx, nil)
+	}
+}
+
+func (s *regexpMatcher) DoSubmatch() SubmatchFunc {
+	return s.do
+}
+
+// MatchRegexp 用正则表达式来匹配, regexps 可以是 *regexp.Regexp 或 []*regexp.Regexp,
+// 正则表达式只在当前行上匹配, 所以 ^ 表示行首, 详见 ConnWrapper.ExpectRegexp
+func MatchRegexp(regexps interface{}, cb SubmatchFunc) Matcher {
+	switch values := regexps.(type) {
+	case *regexp.Regexp:
+		return &regexpMatcher{
+			regexps: []*regexp.Regexp{values},
+			do:      cb,
+		}
+	case []*regexp.Regexp:
+		return &regexpMatcher{
+			regexps: values,
+			do:      cb,
+		}
+	default:
+		panic(fmt.Errorf("want *regexp.Regexp or []*regexp.Regexp got %T", regexps))
+	}
+}
+
 func Match(prompts interface{}, cb func(Conn, []byte, int) (bool, error)) Matcher {
 	switch values := prompts.(type) {
+	case *regexp.Regexp, []*regexp.Regexp:
+		return MatchRegexp(values, func(conn Conn, bs []byte, idx int, submatches [][]byte) (bool, error) {
+			return cb(conn, bs, idx)
+		})
 	case []string:
 		return &stringMatcher{
 			prompts: values,
@@ -251,30 +316,85 @@ func Match(prompts interface{}, cb func(Conn, []byte, int) (bool, error)) Matche
 			do:      cb,
 		}
 	default:
-		panic(fmt.Errorf("want []string or [][]byte got %T", prompts))
+		panic(fmt.Errorf("want []string, [][]byte or *regexp.Regexp got %T", prompts))
 	}
 }
 
 const maxRetryCount = 1000
 
+// ExpectResult 为 ExpectWithResult 的结果
+type ExpectResult struct {
+	// Matcher 为最后匹配成功的 Matcher, 它可能是 DefaultMatchers 中的一个
+	Matcher Matcher
+	// Index 为匹配的模式在 Matcher.Prompts() (正则表达式时为 Regexps()) 中的序号
+	Index int
+	// Before 为最后一次读到的数据中匹配之前的部分
+	Before []byte
+	// Match 为匹配到的文本
+	Match []byte
+	// Submatches 为正则表达式的子匹配, 第 0 个为整个匹配, 匹配字面量时为 nil
+	Submatches [][]byte
+	// Elapsed 为从开始到匹配成功所用的时间
+	Elapsed time.Duration
+	// Answered 为途中自动回答(Matcher 返回 more 为 true)的次数
+	Answered int
+}
+
 func Expect(ctx context.Context, conn Conn, matchs ...Matcher) error {
+	_, err := ExpectWithResult(ctx, conn, matchs...)
+	return err
+}
+
+// ExpectWithResult 和 Expect 一样, 但返回最后匹配成功的详细信息.
+// Matcher 返回错误时同时返回结果, 读数据出错时结果为 nil
+//
+// 超过 conn 的 ExpectTimeout 或 Deadline 时返回对应的 *TimeoutError
+func ExpectWithResult(ctx context.Context, conn Conn, matchs ...Matcher) (*ExpectResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	startAt := time.Now()
+	ctx, cancel := expectContext(ctx, conn)
+	defer cancel()
 	var matchIdxs = make([]int, 0, len(matchs)+len(DefaultMatchers))
 	var prompts = make([][]byte, 0, len(matchs)+len(DefaultMatchers))
+	var regexpIdxs = make([]int, 0, len(matchs)+len(DefaultMatchers))
+	var regexps []*regexp.Regexp
 
 	for idx := range matchs {
 		matchIdxs = append(matchIdxs, len(prompts))
 		prompts = append(prompts, matchs[idx].Prompts()...)
+		regexpIdxs = append(regexpIdxs, len(regexps))
+		if rm, ok := matchs[idx].(RegexpMatcher); ok {
+			regexps = append(regexps, rm.Regexps()...)
+		}
 	}
 	for idx := range DefaultMatchers {
 		matchIdxs = append(matchIdxs, len(prompts))
 		prompts = append(prompts, DefaultMatchers[idx].Prompts()...)
+		regexpIdxs = append(regexpIdxs, len(regexps))
+		if rm, ok := DefaultMatchers[idx].(RegexpMatcher); ok {
+			regexps = append(regexps, rm.Regexps()...)
+		}
 	}
 
 	more := false
+	timeoutCount := 0
+	var result ExpectResult
 	for retryCount := 0; retryCount < maxRetryCount; retryCount++ {
+		if err := ctxError(ctx); err != nil {
+			return nil, err
+		}
+
 		now := time.Now()
-		idx, recvBytes, err := conn.Expect(prompts)
+		idx, recvBytes, submatches, err := conn.ExpectRegexp(ctx, prompts, regexps)
 		if err != nil {
+			if ctxErr := ctxError(ctx); ctxErr != nil {
+				return nil, ctxErr
+			}
+			if IsTimeoutKind(err, ExpectTimeout) || IsTimeoutKind(err, DeadlineTimeout) {
+				return nil, err
+			}
 			if IsTimeout(err) {
 				// FIXME: READ Timeout
 				// 这个是在现场的一台 迪普 设备上发现的问题, 按理说我 show 配置时
@@ -287,57 +407,99 @@ func Expect(ctx context.Context, conn Conn, matchs ...Matcher) error {
 				// 我添加了一个  TestSSHSimErrorMore 来测试这个场景
 				// 
 				// 原始问题看这个  https://xxxx/xxx-test/test/issues/6892
-				err1 := conn.Sendln([]byte(" "))
+				//
+				// 发空格在其它设备上可能会输入不需要的字符, 所以超时后怎么办由
+				// conn 的 TimeoutPolicy 决定, 缺省的 DefaultTimeoutPolicy 还是发一个空格
+				timeoutCount++
+				err1 := conn.TimeoutPolicy()(conn, timeoutCount, recvBytes, err)
 				if err1 == nil {
 					continue
 				}
 
-				recvBytes = append(recvBytes, []byte("\r\nsendln\r\n")...)
-				recvBytes = append(recvBytes, []byte(err1.Error())...)
-				recvBytes = append(recvBytes, []byte("\r\n")...)
+				if err1 != err {
+					recvBytes = append(recvBytes, []byte("\r\non timeout\r\n")...)
+					recvBytes = append(recvBytes, []byte(err1.Error())...)
+					recvBytes = append(recvBytes, []byte("\r\n")...)
+				}
 			}
 			if bytes.Contains(recvBytes, []byte("Network error:")) {
 				if bytes.Contains(recvBytes, []byte("Connection timed out")) {
-					return &net.OpError{Op: "dial",
+					return nil, &net.OpError{Op: "dial",
 						Net: "tcp",
 						Err: net.UnknownNetworkError(string(recvBytes))}
 				}
-				return errors.New(string(recvBytes))
+				return nil, errors.New(string(recvBytes))
 			}
 
-			err = errors.Wrap(err, "["+strconv.Itoa(retryCount)+","+time.Now().Sub(now).Truncate(time.Second).String()+"] read util '"+string(bytes.Join(prompts, []byte(",")))+"' failed")
-			return errors.WrapWithSuffix(err, "\r\n"+ToHexStringIfNeed(recvBytes))
+			err = errors.Wrap(err, "["+strconv.Itoa(retryCount)+","+time.Now().Sub(now).Truncate(time.Second).String()+"] read util '"+joinPatterns(prompts, regexps)+"' failed")
+			return nil, errors.WrapWithSuffix(err, "\r\n"+ToHexStringIfNeed(recvBytes))
 		}
 
 		foundMatchIndex := -1
-
-		for i := 0; i < len(matchIdxs); i++ {
-			if matchIdxs[i] <= idx && (i == len(matchIdxs)-1 || idx < matchIdxs[i+1]) {
-				foundMatchIndex = i
-				break
+		if idx < len(prompts) {
+			for i := 0; i < len(matchIdxs); i++ {
+				if matchIdxs[i] <= idx && (i == len(matchIdxs)-1 || idx < matchIdxs[i+1]) {
+					foundMatchIndex = i
+					break
+				}
+			}
+		} else if idx < len(prompts)+len(regexps) {
+			for i := 0; i < len(regexpIdxs); i++ {
+				if regexpIdxs[i] <= idx-len(prompts) && (i == len(regexpIdxs)-1 || idx-len(prompts) < regexpIdxs[i+1]) {
+					foundMatchIndex = i
+					break
+				}
 			}
 		}
 
 		if foundMatchIndex < 0 {
-			return errors.New("read util '" + string(bytes.Join(prompts, []byte(","))) + "' failed, return index is '" + strconv.Itoa(idx) + "'")
+			return nil, errors.New("read util '" + joinPatterns(prompts, regexps) + "' failed, return index is '" + strconv.Itoa(idx) + "'")
 		}
 
-		var cb DoFunc
+		var matcher Matcher
 		if len(matchs) > foundMatchIndex {
-			cb = matchs[foundMatchIndex].Do()
+			matcher = matchs[foundMatchIndex]
 		} else {
-			cb = DefaultMatchers[foundMatchIndex-len(matchs)].Do()
+			matcher = DefaultMatchers[foundMatchIndex-len(matchs)]
+		}
+		result.Matcher = matcher
+		if idx < len(prompts) {
+			result.Index = idx - matchIdxs[foundMatchIndex]
+			result.Match = prompts[idx]
+			result.Submatches = nil
+			more, err = matcher.Do()(conn, recvBytes, result.Index)
+		} else {
+			result.Index = idx - len(prompts) - regexpIdxs[foundMatchIndex]
+			result.Match = submatches[0]
+			result.Submatches = submatches
+			more, err = matcher.(RegexpMatcher).DoSubmatch()(conn, recvBytes, result.Index, submatches)
+		}
+		result.Before = recvBytes
+		if pos := bytes.LastIndex(recvBytes, result.Match); pos >= 0 {
+			result.Before = recvBytes[:pos]
 		}
-		more, err = cb(conn, recvBytes, idx-matchIdxs[foundMatchIndex])
+		result.Elapsed = time.Since(startAt)
 		if err != nil {
-			return err
+			return &result, err
 		}
 		if !more {
-			return nil
+			return &result, nil
 		}
+		result.Answered++
 	}
 
-	return errors.New("read util '" + string(bytes.Join(prompts, []byte(","))) + "' failed, retry count > " + strconv.FormatInt(maxRetryCount, 10))
+	return nil, errors.New("read util '" + joinPatterns(prompts, regexps) + "' failed, retry count > " + strconv.FormatInt(maxRetryCount, 10))
+}
+
+func joinPatterns(prompts [][]byte, regexps []*regexp.Regexp) string {
+	s := string(bytes.Join(prompts, []byte(",")))
+	for _, re := range regexps {
+		if s != "" {
+			s += ","
+		}
+		s += "/" + re.String() + "/"
+	}
+	return s
 }
 
 func UserLogin(ctx context.Context, conn Conn, userPrompts [][]byte, username []byte, passwordPrompts [][]byte, password []byte, prompts [][]byte, matchs ...Matcher) ([]byte, error) {
@@ -503,6 +665,34 @@ func readPrompt(ctx context.Context, buf *bytes.Buffer, conn Conn, prompts [][]b
 	return prompt, nil
 }
 
+// ReadPromptRegexp 读取一个用正则表达式描述的提示符, 返回匹配到的文本(去掉前后的空白),
+// 用于提示符中含有事先不知道的主机名等情况, 如 `^\S+(\(config[^)]*\))?#\s*$`
+func ReadPromptRegexp(ctx context.Context, conn Conn, prompt *regexp.Regexp, matchs ...Matcher) ([]byte, error) {
+	var found []byte
+
+	copyed := make([]Matcher, len(matchs)+1)
+	copyed[0] = MatchRegexp(prompt, func(conn Conn, bs []byte, idx int, submatches [][]byte) (bool, error) {
+		found = append([]byte{}, bytes.TrimSpace(submatches[0])...)
+		return false, nil
+	})
+	copy(copyed[1:], matchs)
+
+	for retryCount := 0; found == nil; retryCount++ {
+		if retryCount >= 10 {
+			return nil, errors.New("read prompt failed, retry count > 10")
+		}
+		e := Expect(ctx, conn, copyed...)
+		if nil != e {
+			return nil, e
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, errors.New("read prompt '" + prompt.String() + "' failed, matched is empty")
+	}
+	return found, nil
+}
+
 func GetPrompt(bs []byte, prompts [][]byte) []byte {
 	if len(bs) == 0 {
 		return nil
@@ -578,6 +768,41 @@ func Exec(ctx context.Context, conn Conn, prompt, cmd []byte) ([]byte, error) {
 	return bs, nil
 }
 
+// InterruptWait 为 Interrupt 发送信号后等待提示符的时间, 超过后再在数据流中发送 Ctrl-C
+var InterruptWait = 2 * time.Second
+
+// Interrupt 中断正在执行的命令并等待 prompt 重新出现.
+// 先发送 SignalInterrupt, 连接不支持或在 InterruptWait 内没有等到 prompt 时再在数据流中发送 Ctrl-C,
+// 等到 prompt 后清空已缓存的数据
+func Interrupt(ctx context.Context, conn Conn, prompt []byte) error {
+	if len(prompt) == 0 {
+		return errors.New("prompt is missing")
+	}
+	matcher := Match(prompt, ReturnOK)
+
+	if err := conn.SendSignal(SignalInterrupt); err == nil {
+		waitCtx, cancel := context.WithTimeout(ctx, InterruptWait)
+		err = Expect(waitCtx, conn, matcher)
+		cancel()
+		if err == nil {
+			_, err = conn.DrainOff(0)
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctxError(ctx)
+		}
+	}
+
+	if err := conn.Send([]byte{3}); err != nil {
+		return err
+	}
+	if err := Expect(ctx, conn, matcher); err != nil {
+		return errors.Wrap(err, "interrupt")
+	}
+	_, err := conn.DrainOff(0)
+	return err
+}
+
 func WithEnable(ctx context.Context, conn Conn, enableCmd []byte, passwordPrompts [][]byte, password []byte, enablePrompts [][]byte) ([]byte, error) {
 	if len(enableCmd) == 0 {
 		enableCmd = defaultEnableCmd
@@ -3,8 +3,12 @@ package shell
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io/ioutil"
+	"regexp"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestExpect2(t *testing.T) {
@@ -109,6 +113,134 @@ func TestExpect2(t *testing.T) {
 	}
 }
 
+func TestExpectCancel(t *testing.T) {
+	p := MakePipe(0)
+	conn := MakeConnWrapper(nil, ioutil.Discard, p)
+	// 读超时时 Expect 会发一个空格后重试, 这里保证是 ctx 让它退出的
+	conn.SetReadDeadline(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Exec(ctx, &conn, []byte("ABC>"), []byte("show"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %v got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > 1*time.Second {
+		t.Errorf("cancel is too slow, %s", elapsed)
+	}
+}
+
+func TestExpectNilContext(t *testing.T) {
+	p := MakePipe(0)
+	p.Write([]byte("abc\r\nABC>"))
+	p.Close()
+
+	conn := MakeConnWrapper(nil, ioutil.Discard, p)
+	conn.SetExpectTimeout(time.Second)
+	// ctx 为 nil 时和 context.Background() 一样, 不能 panic
+	if b, err := conn.ReadByteContext(nil); err != nil || b != 'a' {
+		t.Errorf("want 'a' got %q, %v", b, err)
+	}
+	if n, err := conn.ReadContext(nil, make([]byte, 1)); err != nil || n != 1 {
+		t.Errorf("want 1 got %d, %v", n, err)
+	}
+	if err := Expect(nil, &conn, Match("ABC>", ReturnOK)); err != nil {
+		t.Error(err)
+	}
+	if _, _, _, err := conn.ExpectRegexp(nil, [][]byte{[]byte("abcd")}, nil); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestExpectRegexpMatcher(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("Save config to flash:startup.cfg? [Y/N]:")
+
+	var submatches [][]byte
+	var matchedIdx = -1
+	conn := MakeConnWrapper(nil, &buf, &buf)
+	err := Expect(context.Background(), &conn,
+		Match("abc", ReturnOK),
+		MatchRegexp([]*regexp.Regexp{
+			regexp.MustCompile(`^Save to (\S+)\?`),
+			regexp.MustCompile(`^Save config to (\S+)\? \[Y/N\]:`),
+		}, func(conn Conn, bs []byte, idx int, s [][]byte) (bool, error) {
+			matchedIdx = idx
+			submatches = s
+			return false, nil
+		}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if matchedIdx != 1 {
+		t.Error("want 1 got", matchedIdx)
+	}
+	if len(submatches) != 2 || string(submatches[1]) != "flash:startup.cfg" {
+		t.Errorf("want 'flash:startup.cfg' got %q", submatches)
+	}
+}
+
+func TestExpectWithResult(t *testing.T) {
+	p := MakePipe(0)
+	p.Write([]byte("line1\r\n<next page>line2\r\nversion 15.2 (3)\r\nRouter#"))
+	p.Close()
+
+	conn := MakeConnWrapper(nil, ioutil.Discard, p)
+	prompt := Match([]string{"Switch#", "Router#"}, ReturnOK)
+	result, err := ExpectWithResult(context.Background(), &conn,
+		Match("<next page>", SaySpace),
+		prompt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Matcher != prompt || result.Index != 1 || result.Answered != 1 {
+		t.Errorf("want prompt matcher, 1, 1 got %v, %d, %d", result.Matcher.Strings(), result.Index, result.Answered)
+	}
+	if string(result.Match) != "Router#" || string(result.Before) != "line2\r\nversion 15.2 (3)\r\n" || result.Submatches != nil {
+		t.Errorf("want 'Router#' got %q, %q, %q", result.Match, result.Before, result.Submatches)
+	}
+
+	p = MakePipe(0)
+	p.Write([]byte("line1\r\nversion 15.2 (3)\r\nRouter#"))
+	p.Close()
+
+	conn = MakeConnWrapper(nil, ioutil.Discard, p)
+	version := MatchRegexp(regexp.MustCompile(`version (\S+) \((\d+)\)`), func(conn Conn, bs []byte, idx int, submatches [][]byte) (bool, error) {
+		return false, nil
+	})
+	result, err = ExpectWithResult(context.Background(), &conn, version, prompt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Matcher != version || result.Index != 0 || result.Answered != 0 || result.Elapsed <= 0 {
+		t.Errorf("want version matcher, 0, 0 got %v, %d, %d, %s", result.Matcher.Strings(), result.Index, result.Answered, result.Elapsed)
+	}
+	if string(result.Match) != "version 15.2 (3)" || string(result.Before) != "line1\r\n" ||
+		len(result.Submatches) != 3 || string(result.Submatches[1]) != "15.2" || string(result.Submatches[2]) != "3" {
+		t.Errorf("want 'version 15.2 (3)' got %q, %q, %q", result.Match, result.Before, result.Submatches)
+	}
+}
+
+func TestReadPromptRegexp(t *testing.T) {
+	p := MakePipe(0)
+	p.Write([]byte("\r\nlast login: 2024-03-21 14:37:22\r\nRouter-7(config-if)# "))
+	p.Close()
+
+	conn := MakeConnWrapper(nil, ioutil.Discard, p)
+	prompt, err := ReadPromptRegexp(context.Background(), &conn, regexp.MustCompile(`^\S+(\(config[^)]*\))?#\s*$`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(prompt) != "Router-7(config-if)#" {
+		t.Errorf("want 'Router-7(config-if)#' got %q", prompt)
+	}
+}
 
 
 var h3ctxt = "\r\n********************************************************************************\r\n*  Copyright(c) 2004-2009 Hangzhou H3C Tech. Co., Ltd. All rights reserved.    *\r\n*  Without the owner's prior written consent,                                  "+
@@ -0,0 +1,120 @@
+package shell
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// JumpHost 为 ssh 跳板机, 和 OpenSSH 的 ProxyJump 相同, 目标的连接通过跳板机的 direct-tcpip 通道建立
+type JumpHost struct {
+	// Host 为 host:port
+	Host       string
+	User       string
+	Password   string
+	PrivateKey string
+	// Options 为认证和主机密钥的设置, 可以为 nil, 其中终端, keepalive 和 JumpHosts 的设置被忽略
+	Options *SSHOptions
+}
+
+// dialFunc 和 net.Dial 相同
+type dialFunc func(network, addr string) (net.Conn, error)
+
+// dialJumpHosts 按顺序连接所有的跳板机, 返回通过最后一个跳板机建立连接的 dial 和关闭所有跳板机的 closeAll.
+// 第一个跳板机(没有跳板机时为目标)用 dialer 建立 tcp 连接, ctx 取消或超时时中断这个连接和跳板机的握手
+func dialJumpHosts(ctx context.Context, dialer ContextDialer, jumps []JumpHost, timeout time.Duration) (dial dialFunc, closeAll func(), err error) {
+	dial = func(network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	var clients []*ssh.Client
+	closeAll = func() {
+		for idx := len(clients) - 1; idx >= 0; idx-- {
+			clients[idx].Close()
+		}
+	}
+	for _, jump := range jumps {
+		var opts SSHOptions
+		if jump.Options != nil {
+			opts = *jump.Options
+		}
+		opts.JumpHosts = nil
+		if opts.Timeout <= 0 {
+			opts.Timeout = timeout
+		}
+
+		client, _, err := dialSSHContext(ctx, dial, jump.Host, jump.User, jump.Password, jump.PrivateKey, &opts)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrap(err, "connect to jump host '"+jump.Host+"'")
+		}
+		clients = append(clients, client)
+		dial = viaClient(client, jump.Host)
+	}
+	return dial, closeAll, nil
+}
+
+func viaClient(client *ssh.Client, jumpHost string) dialFunc {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := client.Dial(network, addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "open '"+addr+"' through jump host '"+jumpHost+"'")
+		}
+		return conn, nil
+	}
+}
+
+// jumpConn 在连接关闭时同时关闭跳板机
+type jumpConn struct {
+	net.Conn
+	once     sync.Once
+	closeAll func()
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.closeAll)
+	return err
+}
+
+// DialTelnetVia 通过 ssh 跳板机连接 telnet 服务, jumps 为按顺序经过的跳板机, 为空时直接连接.
+// 返回的 Telnet 关闭时同时关闭跳板机的连接
+func DialTelnetVia(addr string, jumps []JumpHost, timeout time.Duration) (*Telnet, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return DialTelnetWith(ctx, nil, addr, jumps)
+}
+
+// DialTelnetWith 用 dialer 连接 telnet 服务(有跳板机时连接第一个跳板机), dialer 为 nil 时使用
+// ProxyFromEnvironment(). ctx 取消或超时时中断连接和跳板机的握手
+func DialTelnetWith(ctx context.Context, dialer ContextDialer, addr string, jumps []JumpHost) (*Telnet, error) {
+	dialer, err := dialerOrDefault(dialer)
+	if err != nil {
+		return nil, err
+	}
+	if len(jumps) == 0 {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return NewTelnet(conn), nil
+	}
+	dial, closeAll, err := dialJumpHosts(ctx, dialer, jumps, 0)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial("tcp", addr)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return NewTelnet(&jumpConn{Conn: conn, closeAll: closeAll}), nil
+}
@@ -0,0 +1,132 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim/sshd"
+	"golang.org/x/crypto/ssh"
+)
+
+func waitConnections(t *testing.T, listener *sshd.Server, want int) {
+	t.Helper()
+	for i := 0; i < 100 && listener.Connections() != want; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := listener.Connections(); n != want {
+		t.Errorf("want %d connections got %d", want, n)
+	}
+}
+
+func TestSSHJumpHosts(t *testing.T) {
+	key1, key2 := newHostKey(t), newHostKey(t)
+	jump1, host1 := startSSHSim(t, simConfig{User: "j1", Password: "p1", Prompt: "JUMP>", HostKey: key1, AllowTCPForwarding: true})
+	defer jump1.Close()
+	jump2, host2 := startSSHSim(t, simConfig{User: "j2", Password: "p2", Prompt: "JUMP>", HostKey: key2, AllowTCPForwarding: true})
+	defer jump2.Close()
+
+	target, targetHost := startSSHSim(t, simConfig{})
+	defer target.Close()
+
+	pinned := func(key ssh.Signer) *SSHOptions {
+		cb, err := PinnedHostKey(ssh.FingerprintSHA256(key.PublicKey()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &SSHOptions{HostKeyCallback: cb}
+	}
+	jumps := []JumpHost{
+		{Host: host1, User: "j1", Password: "p1", Options: pinned(key1)},
+		{Host: host2, User: "j2", Password: "p2", Options: pinned(key2)},
+	}
+
+	conn, err := ConnectSSHWithOptions(targetHost, "abc", "123", "", &SSHOptions{JumpHosts: jumps}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(5 * time.Second)
+	prompt, err := ReadPrompt(context.Background(), conn, [][]byte{[]byte("ABC>")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(prompt), "ABC>") {
+		t.Errorf("want ABC> got %q", prompt)
+	}
+	if jump1.Connections() != 1 || jump2.Connections() != 1 {
+		t.Errorf("jump hosts are not used, %d %d", jump1.Connections(), jump2.Connections())
+	}
+
+	// 关闭后跳板机的连接也关闭了
+	conn.Close()
+	waitConnections(t, jump1, 0)
+	waitConnections(t, jump2, 0)
+
+	// 每个跳板机有自己的密码和主机密钥策略
+	bad := append([]JumpHost{}, jumps...)
+	bad[1].Password = "wrong"
+	_, err = ConnectSSHWithOptions(targetHost, "abc", "123", "", &SSHOptions{JumpHosts: bad}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), host2) {
+		t.Errorf("want error of %s got %v", host2, err)
+	}
+	waitConnections(t, jump1, 0)
+
+	bad = append([]JumpHost{}, jumps...)
+	bad[0].Options = pinned(key2)
+	_, err = ConnectSSHWithOptions(targetHost, "abc", "123", "", &SSHOptions{JumpHosts: bad}, nil, nil)
+	if !errors.Is(err, ErrHostKeyMismatch) || !strings.Contains(err.Error(), host1) {
+		t.Errorf("want mismatch of %s got %v", host1, err)
+	}
+
+	// 跳板机不能转发时
+	_, err = ConnectSSHWithOptions(targetHost, "abc", "123", "", &SSHOptions{
+		JumpHosts: []JumpHost{{Host: targetHost, User: "abc", Password: "123"}},
+	}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "through jump host") {
+		t.Errorf("want rejected got %v", err)
+	}
+
+	// URL
+	c, err := Dial(context.Background(), "ssh://abc:123@"+targetHost+"?jump=j1:p1@"+host1+",j2:p2@"+host2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestTelnetJumpHost(t *testing.T) {
+	jump, jumpHost := startSSHSim(t, simConfig{User: "j1", Password: "p1", Prompt: "JUMP>", HostKey: newHostKey(t), AllowTCPForwarding: true})
+	defer jump.Close()
+
+	target, targetHost := startTelnetSim(t, simConfig{})
+	defer target.Close()
+
+	telnetConn, err := DialTelnetVia(targetHost, []JumpHost{{Host: jumpHost, User: "j1", Password: "p1"}}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := TelnetWrap(telnetConn, nil, nil)
+	conn.UseCRLF()
+	conn.SetReadDeadline(5 * time.Second)
+	prompt, err := UserLogin(context.Background(), conn, nil, []byte("abc"), nil, []byte("123"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(prompt), "ABC>") {
+		t.Errorf("want ABC> got %q", prompt)
+	}
+	if jump.Connections() != 1 {
+		t.Errorf("jump host is not used")
+	}
+	conn.Close()
+	waitConnections(t, jump, 0)
+
+	c, err := Dial(context.Background(), "telnet://"+targetHost+"?jump=j1:p1@"+jumpHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	waitConnections(t, jump, 0)
+}
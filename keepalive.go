@@ -0,0 +1,111 @@
+package shell
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKeepaliveMaxMissed 为缺省的 keepalive 最多连续没有响应的次数
+const DefaultKeepaliveMaxMissed = 3
+
+// ErrPeerDead 表示对端已经没有响应了, 所有的 *KeepaliveError 都满足 errors.Is(err, ErrPeerDead)
+var ErrPeerDead = errors.New("peer is dead")
+
+var errKeepaliveNoReply = errors.New("keepalive is no reply")
+
+// KeepaliveError 表示对端连续 Missed 次没有响应 keepalive, 连接已经被关闭.
+// Expect 等读操作在读完已收到的数据后返回它
+type KeepaliveError struct {
+	Interval time.Duration
+	Missed   int
+	// Err 为最后一次 keepalive 失败的原因
+	Err error
+}
+
+func (e *KeepaliveError) Error() string {
+	s := "peer is dead, missed " + strconv.Itoa(e.Missed) + " keepalives(" + e.Interval.String() + ")"
+	if e.Err != nil {
+		s += ", " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *KeepaliveError) Is(target error) bool {
+	return target == ErrPeerDead
+}
+
+func (e *KeepaliveError) Unwrap() error {
+	return e.Err
+}
+
+// keepalive 每隔 interval 调用一次 ping, ping 返回错误或在 interval 内没有返回时算作一次没有响应,
+// 即每个 ping 的超时等于 interval. 同一时间只有一个 ping, 上一个 ping 还没有返回时不会发新的,
+// 它每多等一个 interval 就再算一次没有响应. 连续 maxMissed 次没有响应时用 *KeepaliveError 调用 dead.
+// done 关闭后退出. idle 不为 nil 时它返回连接已经多久没有收到数据了, 在 interval 内收到过数据时不用 ping
+func keepalive(interval time.Duration, maxMissed int, done <-chan struct{}, idle func() time.Duration, ping func() error, dead func(error)) {
+	if maxMissed <= 0 {
+		maxMissed = DefaultKeepaliveMaxMissed
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	var pending chan error // 正在等待的 ping, 为 nil 时没有
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case err = <-pending:
+			pending = nil
+			if err == nil {
+				missed = 0
+				continue
+			}
+		case <-ticker.C:
+			if idle != nil && idle() < interval {
+				missed = 0
+				continue
+			}
+			if pending == nil {
+				pending = make(chan error, 1)
+				go func(result chan<- error) {
+					result <- ping()
+				}(pending)
+				continue
+			}
+			err = errKeepaliveNoReply
+		}
+
+		missed++
+		if missed >= maxMissed {
+			dead(&KeepaliveError{Interval: interval, Missed: missed, Err: err})
+			return
+		}
+	}
+}
+
+// StartSSHKeepalive 每隔 interval 在 client 上发送一个 keepalive@openssh.com 请求, 每个请求最多等待 interval,
+// 上一个请求没有回应时不再发送新的. 连续 maxMissed 次没有回应时用 *KeepaliveError 调用 onDead(可以为 nil),
+// 然后关闭 client. client 关闭后自动停止
+func StartSSHKeepalive(client *ssh.Client, interval time.Duration, maxMissed int, onDead func(error)) {
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+
+	go keepalive(interval, maxMissed, done, nil, func() error {
+		// 对端不认识这个请求时会回答 false, 这也说明它还活着
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		return err
+	}, func(err error) {
+		if onDead != nil {
+			onDead(err)
+		}
+		client.Close()
+	})
+}
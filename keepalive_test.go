@@ -0,0 +1,245 @@
+package shell
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
+	"github.com/runner-mei/errors"
+)
+
+// freezeProxy 为一个 tcp 代理, freeze 后不再转发任何数据但也不关闭连接, 用于模拟对端死掉或被防火墙丢弃
+type freezeProxy struct {
+	listener net.Listener
+	target   string
+
+	mu     sync.Mutex
+	frozen chan struct{}
+}
+
+func startFreezeProxy(t *testing.T, target string) *freezeProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &freezeProxy{listener: listener, target: target}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serve(conn)
+		}
+	}()
+	return proxy
+}
+
+func (proxy *freezeProxy) Addr() string {
+	return proxy.listener.Addr().String()
+}
+
+func (proxy *freezeProxy) Close() error {
+	proxy.Unfreeze()
+	return proxy.listener.Close()
+}
+
+func (proxy *freezeProxy) Freeze() {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if proxy.frozen == nil {
+		proxy.frozen = make(chan struct{})
+	}
+}
+
+func (proxy *freezeProxy) Unfreeze() {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if proxy.frozen != nil {
+		close(proxy.frozen)
+		proxy.frozen = nil
+	}
+}
+
+func (proxy *freezeProxy) wait() {
+	proxy.mu.Lock()
+	frozen := proxy.frozen
+	proxy.mu.Unlock()
+	if frozen != nil {
+		<-frozen
+	}
+}
+
+func (proxy *freezeProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	target, err := net.Dial("tcp", proxy.target)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+
+	copyFn := func(dst io.Writer, src io.Reader) {
+		var buf [1024]byte
+		for {
+			n, err := src.Read(buf[:])
+			if n > 0 {
+				proxy.wait()
+				if _, e := dst.Write(buf[:n]); e != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	go copyFn(target, conn)
+	copyFn(conn, target)
+}
+
+func TestKeepaliveOnePingAtATime(t *testing.T) {
+	interval := 50 * time.Millisecond
+	done := make(chan struct{})
+	defer close(done)
+
+	var mu sync.Mutex
+	pings := 0
+	dead := make(chan error, 1)
+	start := time.Now()
+	go keepalive(interval, 3, done, nil, func() error {
+		mu.Lock()
+		pings++
+		mu.Unlock()
+		<-done // 对端一直不回应
+		return nil
+	}, func(err error) {
+		dead <- err
+	})
+
+	select {
+	case err := <-dead:
+		var kerr *KeepaliveError
+		if !errors.As(err, &kerr) || kerr.Missed != 3 {
+			t.Errorf("want KeepaliveError got %#v", err)
+		}
+	case <-time.After(20 * interval):
+		t.Fatal("dead peer isn't found")
+	}
+	// 第一个 tick 发出 ping, 之后每个 tick 算一次没有响应
+	if elapsed := time.Since(start); elapsed < 4*interval || elapsed > 8*interval {
+		t.Errorf("dead peer is found at %s", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if pings != 1 {
+		t.Errorf("want 1 outstanding ping got %d", pings)
+	}
+}
+
+func testKeepalive(t *testing.T, conn Conn, proxy *freezeProxy, prompt []byte, interval time.Duration) {
+	ctx := context.Background()
+	conn.SetTimeoutPolicy(TimeoutDoNothing)
+	conn.SetReadDeadline(30 * time.Second)
+
+	// keepalive 正常时连接一直可用
+	time.Sleep(3 * interval)
+	if err := conn.Sendln([]byte("echo abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPrompt(ctx, conn, [][]byte{prompt}); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy.Freeze()
+	start := time.Now()
+	_, err := ReadPrompt(ctx, conn, [][]byte{prompt})
+	if err == nil {
+		t.Fatal("want error got ok")
+	}
+	if !errors.Is(err, ErrPeerDead) {
+		t.Errorf("want ErrPeerDead got %v", err)
+	}
+	var kerr *KeepaliveError
+	if !errors.As(err, &kerr) || kerr.Missed != 2 {
+		t.Errorf("want KeepaliveError got %#v", err)
+	}
+	if status := conn.ExitStatus(); status == nil || status.Reason != ClosePeerDead {
+		t.Errorf("want peer dead got %v", status)
+	}
+	if IsTimeout(err) {
+		t.Error("keepalive error is not a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 6*interval {
+		t.Errorf("dead peer is found too late, %s", elapsed)
+	}
+}
+
+func TestSSHKeepalive(t *testing.T) {
+	options := &sshd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", sshd.Echo)
+
+	listener, err := sshd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	proxy := startFreezeProxy(t, net.JoinHostPort("127.0.0.1", listener.Port()))
+	defer proxy.Close()
+
+	conn, err := ConnectSSHWithOptions(proxy.Addr(), "abc", "123", "", &SSHOptions{
+		KeepaliveInterval:  200 * time.Millisecond,
+		KeepaliveMaxMissed: 2,
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	prompt, err := ReadPrompt(context.Background(), conn, [][]byte{[]byte(">")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeepalive(t, conn, proxy, prompt, 200*time.Millisecond)
+}
+
+func TestTelnetKeepaliveAYT(t *testing.T) {
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.Echo)
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	proxy := startFreezeProxy(t, net.JoinHostPort("127.0.0.1", listener.Port()))
+	defer proxy.Close()
+
+	telnetConn, err := DialTelnetTimeout("tcp", proxy.Addr(), 1*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 对 AYT 的回答会出现在数据中, 间隔太短时 UserLogin 中的 DrainOff 会一直读到数据
+	interval := 1500 * time.Millisecond
+	telnetConn.SetKeepalive(interval, 2, true)
+	conn := TelnetWrap(telnetConn, nil, nil)
+	defer conn.Close()
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	prompt, err := UserLogin(context.Background(), conn, nil, []byte("abc"), nil, []byte("123"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeepalive(t, conn, proxy, prompt, interval)
+}
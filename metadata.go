@@ -0,0 +1,261 @@
+package shell
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Metadata 为连接的元数据, 用于审计等
+type Metadata struct {
+	// Transport 为连接的方式, 如 ssh, telnet, serial, plink
+	Transport  string
+	RemoteAddr string
+	LocalAddr  string
+
+	// 下面和 Transport 对应的那一个不为 nil
+	SSH    *SSHMetadata
+	Telnet *TelnetMetadata
+	Serial *SerialMetadata
+}
+
+// SSHMetadata 为 ssh 连接协商的结果
+type SSHMetadata struct {
+	User          string
+	ClientVersion string
+	ServerVersion string
+
+	KeyExchange          string
+	HostKeyAlgorithm     string
+	CipherClientToServer string
+	CipherServerToClient string
+	// MAC 在加密算法为 AEAD(如 aes128-gcm@openssh.com)时为空
+	MACClientToServer string
+	MACServerToClient string
+
+	// HostKeyFingerprint 为服务端主机密钥的 SHA256 指纹, 格式和 ssh-keygen -l 相同
+	HostKeyFingerprint string
+
+	// AuthMethod 为认证成功的方法, 如 password, keyboard-interactive, publickey 或 none
+	AuthMethod string
+	// AuthKeySource 为 publickey 认证成功的密钥的来源, agent 表示 ssh-agent 中的密钥,
+	// key#n 表示第 n 个私钥(从 1 开始, privateKey 参数在 SSHOptions.PrivateKeys 的前面)
+	AuthKeySource string
+	// AuthKeyFingerprint 为 publickey 认证成功的密钥的 SHA256 指纹, 使用证书时为证书中的公钥的指纹
+	AuthKeyFingerprint string
+	// AuthCertKeyID 为认证成功的证书的 key id, 没有使用证书时为空
+	AuthCertKeyID string
+}
+
+// TelnetMetadata 为 telnet 连接协商的结果
+type TelnetMetadata struct {
+	// LocalOptions 为本地已经启用的选项(对端 DO, 本地 WILL), RemoteOptions 为对端已经启用的选项
+	LocalOptions  []string
+	RemoteOptions []string
+	// TermType 为最后一次回答的终端类型
+	TermType string
+	Columns  int
+	Rows     int
+}
+
+// SerialMetadata 为串口的设置
+type SerialMetadata struct {
+	Port     string
+	BaudRate int
+	DataBits int
+	// Parity 为 N, O, E, M 或 S
+	Parity string
+	// StopBits 为 1, 1.5 或 2
+	StopBits string
+}
+
+// MetadataProvider 为可以提供元数据的连接, ConnWrapper 从底层连接中获取它
+type MetadataProvider interface {
+	Metadata() *Metadata
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+var telnetOptionNames = map[byte]string{
+	0:  "BINARY",
+	1:  "ECHO",
+	3:  "SUPPRESS-GO-AHEAD",
+	5:  "STATUS",
+	6:  "TIMING-MARK",
+	24: "TERMINAL-TYPE",
+	31: "NAWS",
+	32: "TERMINAL-SPEED",
+	33: "TOGGLE-FLOW-CONTROL",
+	34: "LINEMODE",
+	35: "X-DISPLAY-LOCATION",
+	36: "ENVIRON",
+	39: "NEW-ENVIRON",
+}
+
+// TelnetOptionName 返回 telnet 选项的名字, 不认识的选项返回它的数字
+func TelnetOptionName(opt byte) string {
+	if name, ok := telnetOptionNames[opt]; ok {
+		return name
+	}
+	return strconv.Itoa(int(opt))
+}
+
+const (
+	sshMsgKexInit = 20
+
+	// maxSniffSize 为记录版本和 KEXINIT 时最多缓存的数据, 超过后放弃
+	maxSniffSize = 256 * 1024
+)
+
+// kexParser 从连接开始的明文数据中解析出版本和第一个 KEXINIT 报文
+type kexParser struct {
+	buf     []byte
+	done    bool
+	version string
+	kexInit []byte
+}
+
+func (p *kexParser) feed(data []byte) {
+	if p.done || len(data) == 0 {
+		return
+	}
+	p.buf = append(p.buf, data...)
+	if len(p.buf) > maxSniffSize {
+		p.done, p.buf = true, nil
+		return
+	}
+
+	// RFC 4253 4.2, 服务端在版本之前可以发送其它的行
+	for p.version == "" {
+		idx := bytes.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			return
+		}
+		line := strings.TrimRight(string(p.buf[:idx]), "\r")
+		p.buf = p.buf[idx+1:]
+		if strings.HasPrefix(line, "SSH-") {
+			p.version = line
+		}
+	}
+
+	if len(p.buf) < 5 {
+		return
+	}
+	length := int(binary.BigEndian.Uint32(p.buf))
+	if len(p.buf) < 4+length {
+		return
+	}
+	padding := int(p.buf[4])
+	if 1+padding < length && p.buf[5] == sshMsgKexInit {
+		p.kexInit = append([]byte{}, p.buf[5:4+length-padding]...)
+	}
+	p.done, p.buf = true, nil
+}
+
+// kexSniffer 记录双方在握手开始时明文发送的版本和 KEXINIT, 用于计算协商出的算法
+type kexSniffer struct {
+	net.Conn
+
+	mu  sync.Mutex
+	in  kexParser
+	out kexParser
+}
+
+func (s *kexSniffer) Read(p []byte) (int, error) {
+	n, err := s.Conn.Read(p)
+	s.mu.Lock()
+	s.in.feed(p[:n])
+	s.mu.Unlock()
+	return n, err
+}
+
+func (s *kexSniffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.out.feed(p)
+	s.mu.Unlock()
+	return s.Conn.Write(p)
+}
+
+// parseKexInit 返回 KEXINIT 中的前 8 个算法列表
+func parseKexInit(payload []byte) ([][]string, bool) {
+	if len(payload) < 17 {
+		return nil, false
+	}
+	payload = payload[17:]
+	lists := make([][]string, 0, 8)
+	for len(lists) < 8 {
+		if len(payload) < 4 {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint32(payload))
+		if len(payload) < 4+n {
+			return nil, false
+		}
+		lists = append(lists, strings.Split(string(payload[4:4+n]), ","))
+		payload = payload[4+n:]
+	}
+	return lists, true
+}
+
+// negotiate 按 RFC 4253 7.1 选择客户端列表中第一个服务端也支持的算法
+func negotiate(client, server []string) string {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+func isAEADCipher(cipher string) bool {
+	return strings.HasSuffix(cipher, "-gcm@openssh.com") || cipher == "chacha20-poly1305@openssh.com"
+}
+
+// fill 将嗅探到的协商结果填到 meta 中
+func (s *kexSniffer) fill(meta *SSHMetadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta.ClientVersion = s.out.version
+	if meta.ServerVersion == "" {
+		meta.ServerVersion = s.in.version
+	}
+	client, ok := parseKexInit(s.out.kexInit)
+	if !ok {
+		return
+	}
+	server, ok := parseKexInit(s.in.kexInit)
+	if !ok {
+		return
+	}
+	meta.KeyExchange = negotiate(client[0], server[0])
+	meta.HostKeyAlgorithm = negotiate(client[1], server[1])
+	meta.CipherClientToServer = negotiate(client[2], server[2])
+	meta.CipherServerToClient = negotiate(client[3], server[3])
+	if !isAEADCipher(meta.CipherClientToServer) {
+		meta.MACClientToServer = negotiate(client[4], server[4])
+	}
+	if !isAEADCipher(meta.CipherServerToClient) {
+		meta.MACServerToClient = negotiate(client[5], server[5])
+	}
+}
+
+// recordHostKey 在 cb 之前记录服务端的主机密钥指纹
+func recordHostKey(meta *SSHMetadata, cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		meta.HostKeyFingerprint = ssh.FingerprintSHA256(key)
+		return cb(hostname, remote, key)
+	}
+}
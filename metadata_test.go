@@ -0,0 +1,142 @@
+package shell
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
+)
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSSHMetadata(t *testing.T) {
+	options := &sshd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", sshd.Echo)
+
+	listener, err := sshd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := ConnectSSH(net.JoinHostPort("127.0.0.1", listener.Port()), "abc", "123", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	md := conn.Metadata()
+	if md == nil || md.SSH == nil {
+		t.Fatalf("metadata is missing, %#v", md)
+	}
+	if md.Transport != "ssh" {
+		t.Errorf("want ssh got %q", md.Transport)
+	}
+	if !strings.HasSuffix(md.RemoteAddr, ":"+listener.Port()) || md.LocalAddr == "" {
+		t.Errorf("addresses are invalid, %q %q", md.RemoteAddr, md.LocalAddr)
+	}
+
+	meta := md.SSH
+	if meta.User != "abc" {
+		t.Errorf("want abc got %q", meta.User)
+	}
+	if !strings.HasPrefix(meta.ClientVersion, "SSH-2.0-") || !strings.HasPrefix(meta.ServerVersion, "SSH-2.0-") {
+		t.Errorf("versions are invalid, %q %q", meta.ClientVersion, meta.ServerVersion)
+	}
+	if !contains(SupportedKeyExchanges, meta.KeyExchange) {
+		t.Errorf("key exchange %q is unknown", meta.KeyExchange)
+	}
+	if meta.HostKeyAlgorithm == "" {
+		t.Error("host key algorithm is empty")
+	}
+	for _, cipher := range []string{meta.CipherClientToServer, meta.CipherServerToClient} {
+		if !contains(SupportedCiphers, cipher) {
+			t.Errorf("cipher %q is unknown", cipher)
+		}
+	}
+	if isAEADCipher(meta.CipherClientToServer) != (meta.MACClientToServer == "") {
+		t.Errorf("mac %q is invalid for %q", meta.MACClientToServer, meta.CipherClientToServer)
+	}
+	if !strings.HasPrefix(meta.HostKeyFingerprint, "SHA256:") {
+		t.Errorf("fingerprint %q is invalid", meta.HostKeyFingerprint)
+	}
+
+	// 返回的是副本
+	meta.User = "changed"
+	if conn.Metadata().SSH.User != "abc" {
+		t.Error("metadata is shared")
+	}
+}
+
+func TestTelnetMetadata(t *testing.T) {
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.Echo)
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	telnetConn, err := DialTelnetTimeout("tcp", net.JoinHostPort("127.0.0.1", listener.Port()), 1*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := TelnetWrap(telnetConn, nil, nil)
+	defer conn.Close()
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	if _, err := UserLogin(context.Background(), conn, nil, []byte("abc"), nil, []byte("123"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	md := conn.Metadata()
+	if md == nil || md.Telnet == nil {
+		t.Fatalf("metadata is missing, %#v", md)
+	}
+	if md.Transport != "telnet" || !strings.HasSuffix(md.RemoteAddr, ":"+listener.Port()) {
+		t.Errorf("unexpected %q %q", md.Transport, md.RemoteAddr)
+	}
+
+	meta := md.Telnet
+	for _, opt := range []string{"TERMINAL-TYPE", "NAWS"} {
+		if !contains(meta.LocalOptions, opt) {
+			t.Errorf("local options %v is missing %s", meta.LocalOptions, opt)
+		}
+	}
+	for _, opt := range []string{"ECHO", "SUPPRESS-GO-AHEAD"} {
+		if !contains(meta.RemoteOptions, opt) {
+			t.Errorf("remote options %v is missing %s", meta.RemoteOptions, opt)
+		}
+	}
+	if meta.TermType != DefaultTermType {
+		t.Errorf("want %s got %q", DefaultTermType, meta.TermType)
+	}
+	if meta.Columns != 255 || meta.Rows != 255 {
+		t.Errorf("want 255x255 got %dx%d", meta.Columns, meta.Rows)
+	}
+}
+
+func TestMetadataUnknown(t *testing.T) {
+	p := MakePipe(0)
+	defer p.Close()
+
+	conn := MakeConnWrapper(nil, nil, p)
+	if md := conn.Metadata(); md != nil {
+		t.Errorf("want nil got %#v", md)
+	}
+}
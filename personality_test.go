@@ -0,0 +1,126 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim/personality"
+	"github.com/mei-rune/shell/sim/telnetd"
+)
+
+func TestPersonalities(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		cmd            string
+		characteristic string
+	}{
+		{name: "cisco", cmd: "show running-config", characteristic: "hostname Switch"},
+		{name: "h3c", cmd: "display current-configuration", characteristic: "sysname H3C"},
+		{name: "huawei", cmd: "display current-configuration", characteristic: "sysname HUAWEI"},
+		{name: "junos", cmd: "show configuration", characteristic: "host-name router;"},
+		{name: "fortios", cmd: "show full-configuration", characteristic: "set hostname \"FGT60E\""},
+		{name: "linux", cmd: "uname -a", characteristic: "GNU/Linux"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, ok := personality.Lookup(test.name)
+			if !ok {
+				t.Error(test.name, "isn't found")
+				return
+			}
+
+			options := &telnetd.Options{}
+			options.AddUserPassword("admin", "123")
+			p.Apply(options)
+
+			listener, err := telnetd.StartServer(":", options)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer listener.Close()
+
+			ctx := context.Background()
+			telnetConn, err := DialTelnetTimeout("tcp", net.JoinHostPort("127.0.0.1", listener.Port()), 1*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			var buf bytes.Buffer
+			conn := TelnetWrap(telnetConn, &buf, &buf)
+			defer func() {
+				conn.Close()
+				t.Log(buf.String())
+			}()
+
+			conn.UseCRLF()
+			conn.SetReadDeadline(1 * time.Second)
+
+			prompt, err := UserLogin(ctx, conn, nil, []byte("admin"), nil, []byte("123"), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if excepted := strings.TrimSpace(p.Modes[0].Prompt); string(prompt) != excepted {
+				t.Errorf("want %q got %q", excepted, prompt)
+				return
+			}
+
+			modes := p.Modes[1:]
+			for len(modes) > 0 && modes[0].Password != "" {
+				excepted := strings.TrimSpace(modes[0].Prompt)
+				prompt, err = WithEnable(ctx, conn, []byte(modes[0].Command), nil, []byte(modes[0].Password), [][]byte{[]byte(excepted)})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if string(prompt) != excepted {
+					t.Errorf("want %q got %q", excepted, prompt)
+					return
+				}
+				modes = modes[1:]
+			}
+
+			output, err := Exec(ctx, conn, prompt, []byte(test.cmd))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			output, err = ParseCmdOutput(append(output, prompt...), []byte(test.cmd), prompt, []byte(test.characteristic))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Contains(output, []byte(test.characteristic)) {
+				t.Errorf("want %q got %q", test.characteristic, output)
+			}
+			if bytes.Contains(output, []byte(p.More)) && p.More != "" {
+				t.Errorf("more isn't removed - %q", output)
+			}
+
+			for _, mode := range modes {
+				excepted := strings.TrimSpace(mode.Prompt)
+				prompt, err = WithView(ctx, conn, []byte(mode.Command), [][]byte{[]byte(excepted)})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if string(prompt) != excepted {
+					t.Errorf("want %q got %q", excepted, prompt)
+					return
+				}
+			}
+
+			output, err = Exec(ctx, conn, prompt, []byte("no-such-command"))
+			if err == nil {
+				t.Errorf("unknown command isn't detected: %q", output)
+			} else if !strings.Contains(err.Error(), "permission") {
+				t.Error(err)
+			}
+		})
+	}
+}
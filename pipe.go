@@ -1,10 +1,9 @@
 package shell
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -22,240 +21,323 @@ var bytecache = sync.Pool{
 	},
 }
 
+// pipe 是一个带锁的环形缓冲区, 写入方(如 telnet 的读协程, ssh 的 Stdout)批量写入,
+// 读取方按字节或批量读取.
+//
+// 关闭后缓冲区中剩余的数据仍然可以读出, 读完后返回 CloseWithError 传入的错误或 io.EOF
 type pipe struct {
-	c                         chan byte
-	isClosed                  int32
+	mu     sync.Mutex
+	buf    []byte
+	start  int // 第一个未读字节的位置
+	length int // 未读字节的个数
+
+	// changed 在缓冲区有变化(写入, 读出或关闭)时被关闭, 用于唤醒等待者, 没有等待者时为 nil
+	changed chan struct{}
+
+	closed bool
+	err    error
+
 	readTimeout, writeTimeout time.Duration
-	err                       atomic.Value
 }
 
 func (c *pipe) SetReadDeadline(t time.Duration) error {
+	c.mu.Lock()
 	c.readTimeout = t
-	return nil //c.rc.SetTimeout(t)
+	c.mu.Unlock()
+	return nil
 }
 
 func (c *pipe) SetWriteDeadline(t time.Duration) error {
+	c.mu.Lock()
 	c.writeTimeout = t
-	return nil //errors.New("notimplented")
-}
-
-type ew struct {
-	err error
+	c.mu.Unlock()
+	return nil
 }
 
 func (c *pipe) getError(de error) error {
-	o := c.err.Load()
-	if o == nil {
+	if c.err == nil {
 		return de
 	}
-	w, ok := o.(*ew)
-	if ok {
-		return w.err
+	return c.err
+}
+
+// waitLocked 返回一个在缓冲区变化时被关闭的 chan, 调用时必须持有锁
+func (c *pipe) waitLocked() <-chan struct{} {
+	if c.changed == nil {
+		c.changed = make(chan struct{})
 	}
-	e, ok := o.(error)
-	if ok {
-		return e
+	return c.changed
+}
+
+func (c *pipe) notifyLocked() {
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+}
+
+// wait 释放锁并等待缓冲区变化, 返回前重新获得锁.
+// 超时返回 false, ctx 被取消时返回 ctx.Err()
+func (c *pipe) wait(ctx context.Context, timer *time.Timer) (bool, error) {
+	changed := c.waitLocked()
+	c.mu.Unlock()
+	defer c.mu.Lock()
+
+	var timeout <-chan time.Time
+	if timer != nil {
+		timeout = timer.C
+	}
+	select {
+	case <-changed:
+		return true, nil
+	case <-timeout:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
 	}
-	return fmt.Errorf("%s", o)
 }
 
 func (c *pipe) CloseWithError(err error) error {
-	if err != nil && err != io.EOF {
-		o := c.err.Load()
-		if o == nil {
-			c.err.Store(&ew{err})
-		}
+	c.mu.Lock()
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
 	}
+	c.mu.Unlock()
 	return c.Close()
 }
 
 func (c *pipe) Close() error {
-	if atomic.CompareAndSwapInt32(&c.isClosed, 0, 1) {
-		close(c.c)
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		c.notifyLocked()
 	}
+	c.mu.Unlock()
 	return nil
 }
 
 func (c *pipe) IsClosed() bool {
-	return atomic.LoadInt32(&c.isClosed) != 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
 }
 
-func (c *pipe) WriteByte(b byte) (err error) {
-	if c.IsClosed() {
-		return c.getError(io.EOF)
+// readLocked 从缓冲区中读出最多 len(p) 个字节, 调用时必须持有锁
+func (c *pipe) readLocked(p []byte) int {
+	n := 0
+	for n < len(p) && c.length > 0 {
+		end := c.start + c.length
+		if end > len(c.buf) {
+			end = len(c.buf)
+		}
+		m := copy(p[n:], c.buf[c.start:end])
+		n += m
+		c.start += m
+		if c.start == len(c.buf) {
+			c.start = 0
+		}
+		c.length -= m
 	}
+	if c.length == 0 {
+		c.start = 0
+	}
+	if n > 0 {
+		c.notifyLocked()
+	}
+	return n
+}
 
-	// fmt.Println("pipe write:", string(p))
-
-	defer func() {
-		if o := recover(); o != nil {
-			err = io.ErrClosedPipe
+// writeLocked 将 p 写入缓冲区的空闲部分, 返回写入的字节数, 调用时必须持有锁
+func (c *pipe) writeLocked(p []byte) int {
+	n := 0
+	for n < len(p) && c.length < len(c.buf) {
+		end := c.start + c.length
+		if end >= len(c.buf) {
+			end -= len(c.buf)
 		}
-	}()
-
-	if c.writeTimeout <= 0 {
-		c.c <- b
-		return nil
+		limit := len(c.buf)
+		if end < c.start {
+			limit = c.start
+		}
+		m := copy(c.buf[end:limit], p[n:])
+		n += m
+		c.length += m
 	}
-
-	timer := time.NewTimer(c.writeTimeout)
-	select {
-	case c.c <- b:
-		timer.Stop()
-		return nil
-	case <-timer.C:
-		return io.ErrShortWrite
+	if n > 0 {
+		c.notifyLocked()
 	}
+	return n
 }
 
-func (c *pipe) Write(p []byte) (n int, err error) {
-	if c.IsClosed() {
+func (c *pipe) WriteByte(b byte) error {
+	_, err := c.Write([]byte{b})
+	return err
+}
+
+// Write 将 p 全部写入缓冲区, 缓冲区满时等待读取方读出.
+// 设置了写超时且超时时返回 io.ErrShortWrite, 等待时被关闭返回 io.ErrClosedPipe
+func (c *pipe) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
 		return 0, c.getError(io.EOF)
 	}
 
-	// fmt.Println("pipe write:", string(p))
-
 	var timer *time.Timer
 	if c.writeTimeout > 0 {
 		timer = time.NewTimer(c.writeTimeout)
+		defer timer.Stop()
 	}
-	defer func() {
-		if o := recover(); o != nil {
-			err = io.ErrClosedPipe
+
+	n := 0
+	for {
+		n += c.writeLocked(p[n:])
+		if n >= len(p) {
+			return n, nil
 		}
-		if timer != nil {
-			timer.Stop()
+
+		ok, _ := c.wait(context.Background(), timer)
+		if c.closed {
+			return n, io.ErrClosedPipe
 		}
-	}()
-	for idx := range p {
-		if timer != nil {
-			select {
-			case c.c <- p[idx]:
-				n = idx + 1
-			case <-timer.C:
-				err = io.ErrShortWrite
-				return
-			}
-		} else {
-			c.c <- p[idx]
-			n = idx + 1
+		if !ok {
+			return n, io.ErrShortWrite
 		}
 	}
-	return
 }
 
 func (c *pipe) Read(p []byte) (int, error) {
-	if c.readTimeout > 0 {
-		timer := time.NewTimer(c.readTimeout)
-		offset := 0
-		for {
-			select {
-			case b, ok := <-c.c:
-				if !ok {
-					timer.Stop()
-					return offset, c.getError(io.EOF)
-				}
-				// fmt.Println("pipe read:", string(b))
-
-				p[offset] = b
-				offset++
-				if len(p) <= offset {
-
-					timer.Stop()
-					return offset, nil
-				}
-			case <-timer.C:
-				return offset, nil
-			}
+	return c.ReadContext(context.Background(), p)
+}
+
+// ReadContext 和 Read 一样, 但 ctx 被取消时会立即返回 ctx.Err()
+//
+// 缓冲区中有数据时立即返回已有的数据; 没有数据时如果设置了读超时则最多等待读超时,
+// 超时后返回 0, nil; 没有设置读超时则立即返回 0, nil
+func (c *pipe) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var timer *time.Timer
+	for {
+		if n := c.readLocked(p); n > 0 {
+			return n, nil
 		}
-	} else {
-		offset := 0
-		for {
-			select {
-			case b, ok := <-c.c:
-				if !ok {
-					return offset, c.getError(io.EOF)
-				}
-				// fmt.Println("pipe read:", string(b))
-
-				p[offset] = b
-				offset++
-				if len(p) <= offset {
-					return offset, nil
-				}
-			default:
-				return offset, nil
-			}
+		if c.closed {
+			return 0, c.getError(io.EOF)
+		}
+		if c.readTimeout <= 0 {
+			return 0, nil
+		}
+		if timer == nil {
+			timer = time.NewTimer(c.readTimeout)
+			defer timer.Stop()
+		}
+
+		ok, err := c.wait(ctx, timer)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, nil
 		}
 	}
 }
 
-func (c *pipe) DrainTo(timeout time.Duration,  w io.Writer) (int, error) {
-	var a [1]byte
+// DrainTo 将缓冲区中的数据全部写到 w 中, 缓冲区空了之后再等待 timeout,
+// 在 timeout 内没有新的数据时返回
+func (c *pipe) DrainTo(timeout time.Duration, w io.Writer) (int, error) {
+	a := bytecache.Get().([]byte)
+	defer bytecache.Put(a)
+
 	offset := 0
 	for {
-		select {
-		case b, ok := <-c.c:
-			if !ok {
-				return offset, c.getError(io.EOF)
+		c.mu.Lock()
+		n := c.readLocked(a)
+		if n == 0 {
+			if c.closed {
+				err := c.getError(io.EOF)
+				c.mu.Unlock()
+				return offset, err
+			}
+			if timeout <= 0 {
+				c.mu.Unlock()
+				return offset, nil
 			}
-			offset++
-			a[0] = b
-
-			w.Write(a[:])
-
-			// fmt.Println("pipe read:", string(b))
-		default:
-			if timeout > 0 {
-				timer := time.NewTimer(timeout)
-				select {
-				case b, ok := <-c.c:
-					timer.Stop()
-					if !ok {
-						return offset, c.getError(io.EOF)
-					}
-					offset++
-					a[0] = b
-
-					w.Write(a[:])
-				case <- timer.C:
-					return offset, nil
-				}
-			} else {
+
+			timer := time.NewTimer(timeout)
+			ok, _ := c.wait(context.Background(), timer)
+			timer.Stop()
+			if ok {
+				n = c.readLocked(a)
+			} else if !c.closed {
+				c.mu.Unlock()
 				return offset, nil
 			}
 		}
+		c.mu.Unlock()
+
+		if n > 0 {
+			offset += n
+			w.Write(a[:n])
+		}
 	}
 }
 
 func (c *pipe) ReadByte() (byte, error) {
-	if c.readTimeout > 0 {
-		timer := time.NewTimer(c.readTimeout)
-		select {
-		case b, ok := <-c.c:
-			timer.Stop()
+	return c.ReadByteContext(context.Background())
+}
 
-			if !ok {
-				return 0, c.getError(io.EOF)
-			}
+// ReadByteContext 和 ReadByte 一样, 但 ctx 被取消时会立即返回 ctx.Err()
+func (c *pipe) ReadByteContext(ctx context.Context) (byte, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
-			// fmt.Println("pipe read:", string(b))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var timer *time.Timer
+	for {
+		if c.length > 0 {
+			b := c.buf[c.start]
+			c.start++
+			if c.start == len(c.buf) {
+				c.start = 0
+			}
+			c.length--
+			c.notifyLocked()
 			return b, nil
-		case <-timer.C:
+		}
+		if c.closed {
+			return 0, c.getError(io.EOF)
+		}
+		if timer == nil && c.readTimeout > 0 {
+			timer = time.NewTimer(c.readTimeout)
+			defer timer.Stop()
+		}
+
+		ok, err := c.wait(ctx, timer)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
 			return 0, ErrTimeout
 		}
 	}
-	b, ok := <-c.c
-	if !ok {
-		return 0, c.getError(io.EOF)
-	}
-	// fmt.Println("pipe read:", string(b))
-	return b, nil
 }
 
 func MakePipe(capacity int) *pipe {
 	if capacity <= 0 {
 		capacity = DefaultPipeBufferSize
 	}
-	return &pipe{c: make(chan byte, capacity)}
+	return &pipe{buf: make([]byte, capacity)}
 }
@@ -0,0 +1,250 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipeReadWrite(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, byByte := range []bool{true, false} {
+		p := MakePipe(16)
+		p.SetReadDeadline(1 * time.Second)
+		go func() {
+			for i := 0; i < len(data); i += 37 {
+				end := i + 37
+				if end > len(data) {
+					end = len(data)
+				}
+				if _, err := p.Write(data[i:end]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			p.Close()
+		}()
+
+		var actual []byte
+		var buf [7]byte
+		for {
+			if byByte {
+				b, err := p.ReadByte()
+				if err != nil {
+					if err != io.EOF {
+						t.Error(err)
+					}
+					break
+				}
+				actual = append(actual, b)
+			} else {
+				n, err := p.Read(buf[:])
+				actual = append(actual, buf[:n]...)
+				if err != nil {
+					if err != io.EOF {
+						t.Error(err)
+					}
+					break
+				}
+			}
+		}
+		if !bytes.Equal(actual, data) {
+			t.Errorf("byByte=%v: want %v got %v", byByte, data, actual)
+		}
+	}
+}
+
+func TestPipeTimeout(t *testing.T) {
+	p := MakePipe(4)
+	p.SetReadDeadline(50 * time.Millisecond)
+	p.SetWriteDeadline(50 * time.Millisecond)
+
+	if _, err := p.ReadByte(); err != ErrTimeout {
+		t.Errorf("want ErrTimeout got %v", err)
+	}
+	var buf [4]byte
+	if n, err := p.Read(buf[:]); n != 0 || err != nil {
+		t.Errorf("want 0, nil got %d, %v", n, err)
+	}
+
+	n, err := p.Write([]byte("abcdef"))
+	if n != 4 || err != io.ErrShortWrite {
+		t.Errorf("want 4, ErrShortWrite got %d, %v", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.ReadByteContext(ctx); err != context.Canceled {
+		t.Errorf("want context.Canceled got %v", err)
+	}
+}
+
+func TestPipeCloseWithError(t *testing.T) {
+	p := MakePipe(0)
+	p.SetReadDeadline(1 * time.Second)
+	p.Write([]byte("abc"))
+
+	closeErr := errors.New("close with error")
+	p.CloseWithError(closeErr)
+	p.CloseWithError(errors.New("other error"))
+
+	var buf [8]byte
+	n, err := p.Read(buf[:])
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Errorf("want 'abc' got %q, %v", buf[:n], err)
+	}
+	if _, err := p.ReadByte(); err != closeErr {
+		t.Errorf("want %v got %v", closeErr, err)
+	}
+	if _, err := p.Write([]byte("abc")); err != closeErr {
+		t.Errorf("want %v got %v", closeErr, err)
+	}
+
+	// 写入方等待时被关闭
+	p = MakePipe(2)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p.Close()
+	}()
+	if _, err := p.Write([]byte("abcd")); err != io.ErrClosedPipe {
+		t.Errorf("want ErrClosedPipe got %v", err)
+	}
+}
+
+func TestPipeDrainTo(t *testing.T) {
+	p := MakePipe(0)
+	p.Write([]byte("abc"))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		p.Write([]byte("def"))
+	}()
+
+	var buf bytes.Buffer
+	n, err := p.DrainTo(200*time.Millisecond, &buf)
+	if err != nil || n != 6 || buf.String() != "abcdef" {
+		t.Errorf("want 6, 'abcdef' got %d, %q, %v", n, buf.String(), err)
+	}
+
+	p.Write([]byte("ghi"))
+	p.Close()
+	buf.Reset()
+	n, err = p.DrainTo(0, &buf)
+	if err != io.EOF || n != 3 || buf.String() != "ghi" {
+		t.Errorf("want 3, 'ghi', EOF got %d, %q, %v", n, buf.String(), err)
+	}
+}
+
+// chanPipe 是以前基于 chan byte 的实现, 只用于和 pipe 做性能比较
+type chanPipe struct {
+	c           chan byte
+	isClosed    int32
+	readTimeout time.Duration
+}
+
+func (c *chanPipe) Close() error {
+	if atomic.CompareAndSwapInt32(&c.isClosed, 0, 1) {
+		close(c.c)
+	}
+	return nil
+}
+
+func (c *chanPipe) Write(p []byte) (int, error) {
+	for idx := range p {
+		c.c <- p[idx]
+	}
+	return len(p), nil
+}
+
+func (c *chanPipe) Read(p []byte) (int, error) {
+	timer := time.NewTimer(c.readTimeout)
+	defer timer.Stop()
+	offset := 0
+	for {
+		select {
+		case b, ok := <-c.c:
+			if !ok {
+				return offset, io.EOF
+			}
+			p[offset] = b
+			offset++
+			if len(p) <= offset {
+				return offset, nil
+			}
+		case <-timer.C:
+			return offset, nil
+		}
+	}
+}
+
+func (c *chanPipe) ReadByte() (byte, error) {
+	timer := time.NewTimer(c.readTimeout)
+	defer timer.Stop()
+	select {
+	case b, ok := <-c.c:
+		if !ok {
+			return 0, io.EOF
+		}
+		return b, nil
+	case <-timer.C:
+		return 0, ErrTimeout
+	}
+}
+
+type benchPipe interface {
+	io.ReadWriteCloser
+	io.ByteReader
+}
+
+func benchmarkPipe(b *testing.B, makePipe func() benchPipe, byByte bool) {
+	const size = 1024 * 1024
+	chunk := bytes.Repeat([]byte("interface GigabitEthernet0/1\r\n"), 64)
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := makePipe()
+		go func() {
+			for written := 0; written < size; written += len(chunk) {
+				p.Write(chunk)
+			}
+			p.Close()
+		}()
+
+		var buf [4096]byte
+		for {
+			var err error
+			if byByte {
+				_, err = p.ReadByte()
+			} else {
+				_, err = p.Read(buf[:])
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkPipe(b *testing.B) {
+	ring := func() benchPipe {
+		p := MakePipe(0)
+		p.SetReadDeadline(time.Second)
+		return p
+	}
+	channel := func() benchPipe {
+		return &chanPipe{c: make(chan byte, DefaultPipeBufferSize), readTimeout: time.Second}
+	}
+
+	b.Run("ring/ReadByte", func(b *testing.B) { benchmarkPipe(b, ring, true) })
+	b.Run("chan/ReadByte", func(b *testing.B) { benchmarkPipe(b, channel, true) })
+	b.Run("ring/Read", func(b *testing.B) { benchmarkPipe(b, ring, false) })
+	b.Run("chan/Read", func(b *testing.B) { benchmarkPipe(b, channel, false) })
+}
@@ -78,12 +78,29 @@ type PlinkClient struct {
 }
 
 func (c *PlinkClient) Close() error {
+	c.exit.markClosing()
 	if e := c.cmd.Process.Kill(); nil != e {
 		return e
 	}
 
 	c.ConnWrapper.Close()
-	return c.cmd.Wait()
+	// cmd.Wait() 已经在 ConnectPlink 中调用了, 这里只等它结束并返回它的错误
+	<-c.exit.done
+	return c.exit.status.Err
+}
+
+// plinkExitStatus 将 plink 进程的退出码作为远端的退出码, plink 会将远端的退出码作为自己的退出码
+func plinkExitStatus(err error) *ExitStatus {
+	if err == nil {
+		return &ExitStatus{Reason: CloseExit, Code: 0}
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return &ExitStatus{Reason: CloseExit, Code: code, Err: err}
+		}
+		return &ExitStatus{Reason: CloseSignal, Code: -1, Err: err}
+	}
+	return &ExitStatus{Reason: CloseReset, Code: -1, Err: err}
 }
 
 var tmpseed = time.Now().Unix()
@@ -139,17 +156,22 @@ func ConnectPlink(host, username, password, privateKey string, sWriter, cWriter
 		cWriter = stdin
 	}
 
+	exit := newExitRecorder()
 	go func() {
 		err := cmd.Wait()
-		if err != nil {
-			p.CloseWithError(err)
-		}
+		exit.set(plinkExitStatus(err))
+		p.CloseWithError(err)
 	}()
 
 	pClient := &PlinkClient{
 		cmd: cmd,
 	}
+	pClient.exit = exit
+	pClient.metadata = func() *Metadata {
+		return &Metadata{Transport: "plink", RemoteAddr: host}
+	}
 	pClient.Init(closeFunc(func() error {
+		exit.markClosing()
 		if e := cmd.Process.Kill(); nil != e {
 			return e
 		}
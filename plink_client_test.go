@@ -5,14 +5,22 @@ import (
 	"context"
 	"net"
 	_ "net/http/pprof"
+	"os"
 	"testing"
 	"time"
 
-	// "github.com/mei-rune/shell/sim/sshd"
-	"tech.hengwei.com.cn/go/private/sim/sshd"
+	"github.com/mei-rune/shell/sim/sshd"
 )
 
+func skipIfNoPlink(t *testing.T) {
+	if _, err := os.Stat(PlinkPath); err != nil {
+		t.Skip("plink isn't found -", err)
+	}
+}
+
 func TestPlinkSimSimple(t *testing.T) {
+	skipIfNoPlink(t)
+
 	options := &sshd.Options{}
 	options.AddUserPassword("abc", "123")
 
@@ -65,6 +73,8 @@ func TestPlinkSimSimple(t *testing.T) {
 }
 
 func TestPlinkSimWithEnable(t *testing.T) {
+	skipIfNoPlink(t)
+
 	options := &sshd.Options{}
 	options.AddUserPassword("abc", "123")
 
@@ -101,6 +111,8 @@ func TestPlinkSimWithEnable(t *testing.T) {
 }
 
 func TestPlinkSimWithEnableNonePassword(t *testing.T) {
+	skipIfNoPlink(t)
+
 	options := &sshd.Options{}
 	options.AddUserPassword("abc", "123")
 
@@ -137,6 +149,8 @@ func TestPlinkSimWithEnableNonePassword(t *testing.T) {
 }
 
 func TestPlinkSimWithEnableEmptyPassword(t *testing.T) {
+	skipIfNoPlink(t)
+
 	options := &sshd.Options{}
 	options.AddUserPassword("abc", "123")
 
@@ -179,6 +193,8 @@ func TestPlinkSimWithEnableEmptyPassword(t *testing.T) {
 }
 
 func TestPlinkSimWithYesNo(t *testing.T) {
+	skipIfNoPlink(t)
+
 	options := &sshd.Options{}
 	options.AddUserPassword("abc", "123")
 
@@ -215,6 +231,8 @@ func TestPlinkSimWithYesNo(t *testing.T) {
 }
 
 func TestPlinkSimWithEnableWithYesNo(t *testing.T) {
+	skipIfNoPlink(t)
+
 	options := &sshd.Options{}
 	options.AddUserPassword("abc", "123")
 
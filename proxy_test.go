@@ -0,0 +1,193 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim/proxyd"
+)
+
+// deviceHost 是一个不能解析的主机名, 只有代理才能连接它
+const deviceHost = "device.invalid"
+
+func startProxy(t *testing.T, start func(string, *proxyd.Options) (*proxyd.Server, error), username, password string) (*proxyd.Server, string) {
+	proxy, err := start(":", &proxyd.Options{
+		Username: username,
+		Password: password,
+		Hosts:    map[string]string{deviceHost: "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proxy, net.JoinHostPort("127.0.0.1", proxy.Port())
+}
+
+func telnetLoginVia(t *testing.T, dialer ContextDialer, addr string) {
+	t.Helper()
+	telnetConn, err := DialTelnetWith(context.Background(), dialer, addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := TelnetWrap(telnetConn, nil, nil)
+	defer conn.Close()
+	conn.UseCRLF()
+	conn.SetReadDeadline(5 * time.Second)
+	prompt, err := UserLogin(context.Background(), conn, nil, []byte("abc"), nil, []byte("123"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(prompt), "ABC>") {
+		t.Errorf("want ABC> got %q", prompt)
+	}
+}
+
+func TestProxyDialers(t *testing.T) {
+	sshServer, _ := startSSHSim(t, simConfig{})
+	defer sshServer.Close()
+	telnetServer, _ := startTelnetSim(t, simConfig{})
+	defer telnetServer.Close()
+	sshHost := net.JoinHostPort(deviceHost, sshServer.Port())
+	telnetHost := net.JoinHostPort(deviceHost, telnetServer.Port())
+
+	for _, test := range []struct {
+		scheme string
+		start  func(string, *proxyd.Options) (*proxyd.Server, error)
+	}{
+		{"socks5", proxyd.StartSOCKS5},
+		{"http", proxyd.StartHTTPConnect},
+	} {
+		t.Run(test.scheme, func(t *testing.T) {
+			proxy, proxyHost := startProxy(t, test.start, "u1", "p1")
+			defer proxy.Close()
+
+			dialer, err := ParseProxy(test.scheme + "://u1:p1@" + proxyHost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := ConnectSSHWithOptions(sshHost, "abc", "123", "", &SSHOptions{Dialer: dialer, Timeout: 5 * time.Second}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proxy.Connections() != 1 {
+				t.Errorf("proxy is not used")
+			}
+			conn.Close()
+
+			telnetLoginVia(t, dialer, telnetHost)
+
+			// URL
+			proxyURL := url.QueryEscape(test.scheme + "://u1:p1@" + proxyHost)
+			for _, rawurl := range []string{
+				"ssh://abc:123@" + sshHost + "?proxy=" + proxyURL,
+				"telnet://" + telnetHost + "?proxy=" + proxyURL,
+			} {
+				c, err := Dial(context.Background(), rawurl)
+				if err != nil {
+					t.Fatal(rawurl, err)
+				}
+				c.Close()
+			}
+
+			for _, auth := range []string{"u1:wrong@", ""} {
+				dialer, err := ParseProxy(test.scheme + "://" + auth + proxyHost)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = DialTelnetWith(context.Background(), dialer, telnetHost, nil)
+				if !errors.Is(err, ErrProxyAuth) {
+					t.Errorf("%q: want auth failed got %v", auth, err)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	sshServer, _ := startSSHSim(t, simConfig{})
+	defer sshServer.Close()
+	telnetServer, _ := startTelnetSim(t, simConfig{})
+	defer telnetServer.Close()
+	proxy, proxyHost := startProxy(t, proxyd.StartSOCKS5, "", "")
+	defer proxy.Close()
+
+	t.Setenv("all_proxy", "")
+	t.Setenv("no_proxy", "")
+	t.Setenv("ALL_PROXY", "socks5://"+proxyHost)
+	t.Setenv("NO_PROXY", "")
+
+	conn, err := ConnectSSH(net.JoinHostPort(deviceHost, sshServer.Port()), "abc", "123", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	telnetLoginVia(t, nil, net.JoinHostPort(deviceHost, telnetServer.Port()))
+
+	telnetConn, err := DialTelnetTimeout("tcp", net.JoinHostPort(deviceHost, telnetServer.Port()), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	telnetConn.Close()
+
+	// 明确指定时不使用 ALL_PROXY
+	direct, err := ParseProxy("direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DialTelnetWith(context.Background(), direct, net.JoinHostPort(deviceHost, telnetServer.Port()), nil); err == nil {
+		t.Error("want error")
+	}
+
+	// 回环地址和 NO_PROXY 中的地址不使用代理
+	t.Setenv("ALL_PROXY", "socks5://127.0.0.1:1")
+	telnetLoginVia(t, nil, net.JoinHostPort("127.0.0.1", telnetServer.Port()))
+	t.Setenv("NO_PROXY", ".invalid")
+	if _, err := DialTelnetTimeout("tcp", net.JoinHostPort(deviceHost, telnetServer.Port()), 5*time.Second); err == nil || strings.Contains(err.Error(), "proxy") {
+		t.Errorf("want direct got %v", err)
+	}
+
+	t.Setenv("ALL_PROXY", "ftp://"+proxyHost)
+	if _, err := ConnectSSH(net.JoinHostPort(deviceHost, sshServer.Port()), "abc", "123", "", nil, nil); err == nil || !strings.Contains(err.Error(), "ALL_PROXY") {
+		t.Errorf("want invalid ALL_PROXY got %v", err)
+	}
+}
+
+func TestBypassProxy(t *testing.T) {
+	for _, test := range []struct {
+		addr    string
+		noProxy string
+		bypass  bool
+	}{
+		{"localhost:22", "", true},
+		{"127.0.0.2:22", "", true},
+		{"[::1]:22", "", true},
+		{"10.0.0.1:22", "", false},
+		{"10.0.0.1:22", "10.0.0.0/8", true},
+		{"10.0.0.1:22", "10.0.0.1", true},
+		{"10.0.0.1:22", "10.0.0.2, 10.0.0.1:23", false},
+		{"10.0.0.1:23", "10.0.0.2, 10.0.0.1:23", true},
+		{"sw1.example.com:22", "example.com:23", false},
+		{"sw1.example.com:23", "example.com:23", true},
+		{"sw1.example.com:22", ".example.com", true},
+		{"sw1.example.com:22", "example.com", true},
+		{"sw1.example.com:22", "*.example.com", true},
+		{"example.com:22", ".example.com", true},
+		{"sw1.example.org:22", "example.com", false},
+		{"badexample.com:22", "example.com", false},
+		{"sw1.example.com:22", "*", true},
+	} {
+		if bypass := bypassProxy(test.addr, test.noProxy); bypass != test.bypass {
+			t.Errorf("%s %q: want %v got %v", test.addr, test.noProxy, test.bypass, bypass)
+		}
+	}
+
+	for _, proxy := range []string{"ftp://127.0.0.1:21", "socks5://[::1"} {
+		if _, err := ParseProxy(proxy); err == nil {
+			t.Errorf("%s: want error", proxy)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+)
+
+// ErrUnexpectedOutgoing 为回放时客户端发送的数据与记录不一致
+var ErrUnexpectedOutgoing = errors.New("unexpected outgoing")
+
+// TranscriptEvent 为会话记录中的一段数据
+type TranscriptEvent struct {
+	// Outgoing 为 true 时是客户端发送的数据, 否则为设备返回的数据
+	Outgoing bool   `json:"outgoing,omitempty"`
+	Data     string `json:"data"`
+}
+
+// Transcript 为一个会话的记录, 可以用 NewReplayConn 回放
+//
+// 回放时客户端发送的 "********" 可以匹配任意的密码(SendPassword 在记录中就是这样的)
+type Transcript []TranscriptEvent
+
+func (t *Transcript) add(outgoing bool, data string) {
+	if data == "" {
+		return
+	}
+	if n := len(*t); n > 0 && (*t)[n-1].Outgoing == outgoing {
+		(*t)[n-1].Data += data
+		return
+	}
+	*t = append(*t, TranscriptEvent{Outgoing: outgoing, Data: data})
+}
+
+// Incoming 添加一段设备返回的数据
+func (t *Transcript) Incoming(data string) {
+	t.add(false, data)
+}
+
+// Outgoing 添加一段客户端发送的数据
+func (t *Transcript) Outgoing(data string) {
+	t.add(true, data)
+}
+
+// ReadTranscript 读一个 json 格式的会话记录
+func ReadTranscript(r io.Reader) (Transcript, error) {
+	var t Transcript
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseTaggedTranscript 解析用 SafeWriter.WriteWithTag 记录的日志, inTag 和
+// outTag 分别为设备返回的数据和客户端发送的数据的标签, 如 "S" 和 "C"
+func ParseTaggedTranscript(bs []byte, inTag, outTag string) (Transcript, error) {
+	inMark := []byte("\r\n" + inTag + ": ")
+	outMark := []byte("\r\n" + outTag + ": ")
+
+	var t Transcript
+	outgoing := false
+	started := false
+	for len(bs) > 0 {
+		var mark []byte
+		idx := -1
+		if i := bytes.Index(bs, inMark); i >= 0 {
+			idx, mark = i, inMark
+		}
+		if i := bytes.Index(bs, outMark); i >= 0 && (idx < 0 || i < idx) {
+			idx, mark = i, outMark
+		}
+
+		segment := bs
+		if idx >= 0 {
+			segment = bs[:idx]
+		}
+		if started {
+			data, err := unescapeTagged(segment)
+			if err != nil {
+				return nil, err
+			}
+			t.add(outgoing, string(data))
+		} else if len(bytes.TrimSpace(segment)) > 0 {
+			return nil, errors.New("tagged transcript must start with '" + inTag + ": ' or '" + outTag + ": '")
+		}
+		if idx < 0 {
+			break
+		}
+
+		started = true
+		outgoing = bytes.Equal(mark, outMark)
+		bs = bs[idx+len(mark):]
+	}
+	return t, nil
+}
+
+func unescapeTagged(bs []byte) ([]byte, error) {
+	out := make([]byte, 0, len(bs))
+	for i := 0; i < len(bs); i++ {
+		switch bs[i] {
+		case '\\':
+			if i+1 < len(bs) && (bs[i+1] == '[' || bs[i+1] == ']') {
+				i++
+			}
+			out = append(out, bs[i])
+		case '[':
+			if i+3 >= len(bs) || bs[i+3] != ']' {
+				return nil, errors.New("invalid hex escape at " + strconv.Itoa(i))
+			}
+			b, err := hex.DecodeString(string(bs[i+1 : i+3]))
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid hex escape at "+strconv.Itoa(i))
+			}
+			out = append(out, b...)
+			i += 3
+		default:
+			out = append(out, bs[i])
+		}
+	}
+	return out, nil
+}
+
+// TranscriptRecorder 记录一个会话, 将 Incoming() 和 Outgoing() 分别作为
+// Conn.SetTeeReader 和 Conn.SetTeeWriter 的参数即可
+type TranscriptRecorder struct {
+	mu         sync.Mutex
+	transcript Transcript
+}
+
+func (r *TranscriptRecorder) write(outgoing bool, p []byte) (int, error) {
+	r.mu.Lock()
+	r.transcript.add(outgoing, string(p))
+	r.mu.Unlock()
+	return len(p), nil
+}
+
+// Incoming 返回记录设备返回数据的 io.Writer
+func (r *TranscriptRecorder) Incoming() io.Writer {
+	return WriteFunc(func(p []byte) (int, error) {
+		return r.write(false, p)
+	})
+}
+
+// Outgoing 返回记录客户端发送数据的 io.Writer
+func (r *TranscriptRecorder) Outgoing() io.Writer {
+	return WriteFunc(func(p []byte) (int, error) {
+		return r.write(true, p)
+	})
+}
+
+// Transcript 返回已记录的内容
+func (r *TranscriptRecorder) Transcript() Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(Transcript(nil), r.transcript...)
+}
+
+var passwordMask = []byte("********")
+
+// replay 按记录回放会话, 设备返回的数据只有在它之前的客户端数据都发送后才能读到
+type replay struct {
+	mu         sync.Mutex
+	transcript Transcript
+	changed    chan struct{}
+	closed     bool
+	err        error
+
+	// 设备返回数据和客户端发送数据的当前位置
+	inIdx, inOffset   int
+	outIdx, outOffset int
+	inPassword        bool
+
+	readTimeout time.Duration
+}
+
+// NewReplayConn 创建一个回放 transcript 的 Conn, 客户端发送的数据与记录不一致时
+// Write 会返回 ErrUnexpectedOutgoing, 回放完成后读数据会返回 io.EOF
+func NewReplayConn(transcript Transcript) *ReplayConn {
+	r := &replay{
+		transcript: transcript,
+		changed:    make(chan struct{}),
+	}
+	r.inIdx = r.nextIncoming(0)
+	r.outIdx = r.nextOutgoing(0)
+
+	conn := &ReplayConn{replay: r}
+	conn.Init(r, r, r)
+	return conn
+}
+
+// ReplayConn 是回放会话记录的 Conn
+type ReplayConn struct {
+	ConnWrapper
+	replay *replay
+}
+
+// Verify 检查记录中客户端的数据是否都已发送, 并返回回放中的第一个错误
+func (c *ReplayConn) Verify() error {
+	c.replay.mu.Lock()
+	defer c.replay.mu.Unlock()
+
+	if c.replay.err != nil {
+		return c.replay.err
+	}
+	if c.replay.outIdx < len(c.replay.transcript) {
+		remain := c.replay.transcript[c.replay.outIdx].Data[c.replay.outOffset:]
+		return errors.WrapWithSuffix(ErrUnexpectedOutgoing, "excepted '"+ToHexStringIfNeed([]byte(remain))+"' isn't sent")
+	}
+	return nil
+}
+
+func (r *replay) nextIncoming(idx int) int {
+	for idx < len(r.transcript) && (r.transcript[idx].Outgoing || r.transcript[idx].Data == "") {
+		idx++
+	}
+	return idx
+}
+
+func (r *replay) nextOutgoing(idx int) int {
+	for idx < len(r.transcript) && (!r.transcript[idx].Outgoing || r.transcript[idx].Data == "") {
+		idx++
+	}
+	return idx
+}
+
+func (r *replay) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// readable 返回当前可读的数据, 调用时必须持有锁
+func (r *replay) readable() ([]byte, error) {
+	if r.closed {
+		return nil, io.ErrClosedPipe
+	}
+	if r.inIdx >= len(r.transcript) {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, io.EOF
+	}
+	if r.outIdx < r.inIdx {
+		return nil, nil
+	}
+	return []byte(r.transcript[r.inIdx].Data[r.inOffset:]), nil
+}
+
+func (r *replay) consume(n int) {
+	r.inOffset += n
+	if r.inOffset >= len(r.transcript[r.inIdx].Data) {
+		r.inIdx = r.nextIncoming(r.inIdx + 1)
+		r.inOffset = 0
+	}
+}
+
+// wait 等待可读的数据, timeout 为 0 时一直等待
+func (r *replay) wait(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.NewTimer(timeout)
+		defer timer.Stop()
+	}
+
+	for {
+		r.mu.Lock()
+		bs, err := r.readable()
+		changed := r.changed
+		r.mu.Unlock()
+		if err != nil || len(bs) > 0 {
+			return bs, err
+		}
+
+		if timer == nil {
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, ErrTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (r *replay) SetReadDeadline(timeout time.Duration) error {
+	r.mu.Lock()
+	r.readTimeout = timeout
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *replay) ReadByte() (byte, error) {
+	return r.ReadByteContext(context.Background())
+}
+
+func (r *replay) ReadByteContext(ctx context.Context) (byte, error) {
+	r.mu.Lock()
+	timeout := r.readTimeout
+	r.mu.Unlock()
+
+	if _, err := r.wait(ctx, timeout); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bs, err := r.readable()
+	if err != nil {
+		return 0, err
+	}
+	if len(bs) == 0 {
+		return 0, ErrTimeout
+	}
+	r.consume(1)
+	return bs[0], nil
+}
+
+func (r *replay) Read(p []byte) (int, error) {
+	return r.ReadContext(context.Background(), p)
+}
+
+func (r *replay) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	timeout := r.readTimeout
+	r.mu.Unlock()
+
+	if _, err := r.wait(ctx, timeout); err != nil {
+		if IsTimeout(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bs, err := r.readable()
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, bs)
+	r.consume(n)
+	return n, nil
+}
+
+func (r *replay) DrainTo(timeout time.Duration, w io.Writer) (int, error) {
+	total := 0
+	for {
+		var bs []byte
+		var err error
+		if timeout > 0 {
+			bs, err = r.wait(context.Background(), timeout)
+		} else {
+			// 和 pipe 一样, timeout 为 0 时只读已有的数据
+			r.mu.Lock()
+			bs, err = r.readable()
+			r.mu.Unlock()
+			if err == nil && len(bs) == 0 {
+				return total, nil
+			}
+		}
+		if err != nil {
+			if IsTimeout(err) {
+				return total, nil
+			}
+			return total, err
+		}
+
+		r.mu.Lock()
+		r.consume(len(bs))
+		r.mu.Unlock()
+
+		w.Write(bs)
+		total += len(bs)
+	}
+}
+
+func (r *replay) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	defer r.notify()
+
+	for idx, b := range p {
+		if r.outIdx >= len(r.transcript) {
+			r.err = errors.WrapWithSuffix(ErrUnexpectedOutgoing, "'"+ToHexStringIfNeed(p[idx:])+"' is sent after the transcript is end")
+			return idx, r.err
+		}
+
+		excepted := r.transcript[r.outIdx].Data[r.outOffset:]
+		if !r.inPassword && bytes.HasPrefix([]byte(excepted), passwordMask) {
+			r.inPassword = true
+			r.outOffset += len(passwordMask)
+			excepted = excepted[len(passwordMask):]
+		}
+
+		if r.inPassword {
+			if len(excepted) == 0 {
+				// 记录以密码结束, 这次写的内容都当作密码
+				r.inPassword = false
+				r.outIdx = r.nextOutgoing(r.outIdx + 1)
+				r.outOffset = 0
+				return len(p), nil
+			}
+			// 密码可以是任意的内容, 直到遇到密码之后的字符
+			if excepted[0] != b {
+				continue
+			}
+			r.inPassword = false
+		} else if excepted[0] != b {
+			r.err = errors.WrapWithSuffix(ErrUnexpectedOutgoing, "excepted '"+ToHexStringIfNeed([]byte(excepted))+"', actual '"+ToHexStringIfNeed(p[idx:])+"'")
+			return idx, r.err
+		}
+
+		r.outOffset++
+		if r.outOffset >= len(r.transcript[r.outIdx].Data) {
+			r.outIdx = r.nextOutgoing(r.outIdx + 1)
+			r.outOffset = 0
+		}
+	}
+	return len(p), nil
+}
+
+func (r *replay) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		r.notify()
+	}
+	return nil
+}
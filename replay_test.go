@@ -0,0 +1,139 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim/telnetd"
+)
+
+func replayLogin(t *testing.T, conn Conn) []byte {
+	ctx := context.Background()
+	prompt, err := UserLogin(ctx, conn, nil, []byte("abc"), nil, []byte("123"), nil)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	output, err := Exec(ctx, conn, prompt, []byte("echo abcd"))
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	return output
+}
+
+func TestReplayRecorded(t *testing.T) {
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.Echo)
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+
+	telnetConn, err := DialTelnetTimeout("tcp", net.JoinHostPort("127.0.0.1", listener.Port()), 1*time.Second)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn := TelnetWrap(telnetConn, nil, nil)
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	var recorder TranscriptRecorder
+	conn.SetTeeReader(recorder.Incoming())
+	conn.SetTeeWriter(recorder.Outgoing())
+
+	excepted := replayLogin(t, conn)
+	conn.Close()
+
+	transcript := recorder.Transcript()
+	if len(transcript) == 0 {
+		t.Error("transcript is empty")
+		return
+	}
+
+	replayConn := NewReplayConn(transcript)
+	replayConn.UseCRLF()
+	replayConn.SetReadDeadline(1 * time.Second)
+	defer replayConn.Close()
+
+	actual := replayLogin(t, replayConn)
+	if !bytes.Equal(actual, excepted) {
+		t.Errorf("want %q got %q", excepted, actual)
+	}
+	if err := replayConn.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayTagged(t *testing.T) {
+	var buf bytes.Buffer
+	w := &SafeWriter{W: &buf}
+	w.WriteWithTag("S", []byte("username:"))
+	w.WriteWithTag("C", []byte("abc\r\n"))
+	w.WriteWithTag("S", []byte("password:"))
+	w.WriteWithTag("C", []byte("********\r\n"))
+	w.WriteWithTag("S", []byte("\xff\xfb\x01[ABC]>"))
+	w.WriteWithTag("C", []byte("echo abcd\r\n"))
+	w.WriteWithTag("S", []byte("echo abcd\r\nprint abcd\r\n[ABC]>"))
+
+	transcript, err := ParseTaggedTranscript(buf.Bytes(), "S", "C")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(transcript) != 7 {
+		t.Errorf("want 7 events got %#v", transcript)
+		return
+	}
+	if transcript[4].Data != "\xff\xfb\x01[ABC]>" {
+		t.Errorf("want %q got %q", "\xff\xfb\x01[ABC]>", transcript[4].Data)
+	}
+
+	conn := NewReplayConn(transcript)
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+	defer conn.Close()
+
+	output := replayLogin(t, conn)
+	if !strings.Contains(string(output), "print abcd") {
+		t.Errorf("want 'print abcd' got %q", output)
+	}
+	if err := conn.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayUnexpectedOutgoing(t *testing.T) {
+	var transcript Transcript
+	transcript.Incoming("ABC>")
+	transcript.Outgoing("echo abcd\r\n")
+	transcript.Incoming("echo abcd\r\nprint abcd\r\nABC>")
+
+	conn := NewReplayConn(transcript)
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+	defer conn.Close()
+
+	ctx := context.Background()
+	prompt, err := ReadPrompt(ctx, conn, [][]byte{[]byte(">")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = Exec(ctx, conn, prompt, []byte("echo 1234"))
+	if err == nil {
+		t.Error("want error got ok")
+	} else if !strings.Contains(err.Error(), ErrUnexpectedOutgoing.Error()) {
+		t.Error(err)
+	}
+}
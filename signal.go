@@ -0,0 +1,52 @@
+package shell
+
+import (
+	"github.com/runner-mei/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Signal 为发给远端的信号, 名字和 ssh 的 signal 请求中的相同
+type Signal string
+
+const (
+	SignalInterrupt Signal = "INT"
+	SignalQuit      Signal = "QUIT"
+	SignalTerminate Signal = "TERM"
+	SignalKill      Signal = "KILL"
+	SignalHangup    Signal = "HUP"
+)
+
+var (
+	ErrSignalUnsupported = errors.New("signal is unsupported")
+	ErrBreakUnsupported  = errors.New("break is unsupported")
+)
+
+// Signaler 为支持带外信号的连接, 如 telnet 的 IAC IP, ssh 的 signal 请求和串口的 break
+type Signaler interface {
+	SendSignal(sig Signal) error
+	SendBreak() error
+}
+
+// sshBreakLength 为 ssh break 请求中 break 的时长, 单位为毫秒
+const sshBreakLength = 500
+
+// sshSession 为 ssh 会话上的带外请求, 如 signal, break 和 window-change
+type sshSession struct {
+	session *ssh.Session
+}
+
+func (s sshSession) SendSignal(sig Signal) error {
+	return s.session.Signal(ssh.Signal(sig))
+}
+
+// SendBreak 发送 RFC 4335 中的 break 请求
+func (s sshSession) SendBreak() error {
+	ok, err := s.session.SendRequest("break", true, ssh.Marshal(struct{ BreakLength uint32 }{sshBreakLength}))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBreakUnsupported
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim"
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
+)
+
+// hungCommand 模拟一个一直不结束的命令, 直到收到 Ctrl+C
+func hungCommand(s *sim.Session, line, args []byte) error {
+	if err := s.WriteString("PING 192.168.1.1 56 data bytes\r\n"); err != nil {
+		return err
+	}
+	for {
+		b, err := s.ReadKey()
+		if err != nil {
+			return err
+		}
+		if b == 3 {
+			return s.WriteString("^C\r\n")
+		}
+	}
+}
+
+func testInterrupt(t *testing.T, conn Conn, prompt []byte) {
+	ctx := context.Background()
+	for _, sendBreak := range []bool{false, true} {
+		if err := conn.Sendln([]byte("ping 192.168.1.1")); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Expect(ctx, conn, Match([][]byte{[]byte("data bytes")}, func(Conn, []byte, int) (bool, error) {
+			return false, nil
+		})); err != nil {
+			t.Error(err)
+			return
+		}
+
+		start := time.Now()
+		var err error
+		if sendBreak {
+			if err = conn.SendBreak(); err == nil {
+				_, err = ReadPrompt(ctx, conn, [][]byte{prompt})
+			}
+		} else {
+			err = Interrupt(ctx, conn, prompt)
+		}
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// 带外信号有效, 不需要等到 InterruptWait 之后再发 Ctrl-C
+		if elapsed := time.Since(start); elapsed >= InterruptWait {
+			t.Errorf("interrupt is too slow, %s", elapsed)
+		}
+	}
+}
+
+func TestTelnetInterrupt(t *testing.T) {
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.OS(telnetd.Commands{
+		"ping": hungCommand,
+	}))
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+
+	telnetConn, err := DialTelnetTimeout("tcp", net.JoinHostPort("127.0.0.1", listener.Port()), 1*time.Second)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn := TelnetWrap(telnetConn, nil, nil)
+	defer conn.Close()
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	prompt, err := UserLogin(context.Background(), conn, nil, []byte("abc"), nil, []byte("123"), nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testInterrupt(t, conn, prompt)
+}
+
+func TestSSHInterrupt(t *testing.T) {
+	options := &sshd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", sshd.OS(sshd.Commands{
+		"ping": hungCommand,
+	}))
+
+	listener, err := sshd.StartServer(":", options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+
+	conn, err := ConnectSSH(net.JoinHostPort("127.0.0.1", listener.Port()), "abc", "123", "", nil, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	prompt, err := ReadPrompt(context.Background(), conn, [][]byte{[]byte(">")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testInterrupt(t, conn, prompt)
+}
+
+func TestSignalFallback(t *testing.T) {
+	p := MakePipe(0)
+	defer p.Close()
+
+	var sent bytes.Buffer
+	conn := MakeConnWrapper(nil, &sent, p)
+	if err := conn.SendSignal(SignalInterrupt); err != nil {
+		t.Error(err)
+	}
+	if sent.String() != "\x03" {
+		t.Errorf("want Ctrl-C got %q", sent.String())
+	}
+	if err := conn.SendSignal(SignalTerminate); err != ErrSignalUnsupported {
+		t.Errorf("want ErrSignalUnsupported got %v", err)
+	}
+	if err := conn.SendBreak(); err != ErrBreakUnsupported {
+		t.Errorf("want ErrBreakUnsupported got %v", err)
+	}
+
+	// 没有带外信号时, Interrupt 在数据流中发送 Ctrl-C
+	p.Write([]byte("^C\r\nABC>"))
+	conn = MakeConnWrapper(nil, ioutil.Discard, p)
+	if err := Interrupt(context.Background(), &conn, []byte("ABC>")); err != nil {
+		t.Error(err)
+	}
+}
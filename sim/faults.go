@@ -0,0 +1,117 @@
+package sim
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjectedDisconnect 为 Faults.DisconnectAfter 触发的断开
+var ErrInjectedDisconnect = errors.New("disconnect injected by faults")
+
+// Faults 为模拟设备注入的故障, 用于测试各种超时恢复和提示符的识别,
+// 随机的部分都由 Seed 决定, 同一个 Seed 下每个会话的行为都是相同的
+type Faults struct {
+	Seed int64
+
+	// Latency 不为 0 时每次输出前随机延时 [0, Latency)
+	Latency time.Duration
+
+	// SplitSize 不为 0 时每次输出都被拆成多次写, 每次为 [1, SplitSize] 个字节
+	SplitSize int
+
+	// DropMore 为分页时不显示 more 提示的概率(0 到 1), 但仍然会等待按键,
+	// 类似现场的一台迪普设备
+	DropMore float64
+
+	// DisconnectAfter 不为 0 时输出了这么多字节后断开连接
+	DisconnectAfter int
+
+	// DuplicateEcho 为 true 时输入的字符都回显两次
+	DuplicateEcho bool
+
+	// NULPadding 为每一行的行尾之后填充的 NUL 字符的个数
+	NULPadding int
+
+	// BackspaceRedraw 为 true 时在命令行输入完成后用退格清除并重新显示一次
+	BackspaceRedraw bool
+}
+
+func (f *Faults) random() *rand.Rand {
+	return rand.New(rand.NewSource(f.Seed))
+}
+
+type faultWriter struct {
+	w      io.Writer
+	faults *Faults
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	written int
+}
+
+func newFaultWriter(w io.Writer, faults *Faults, rnd *rand.Rand) *faultWriter {
+	return &faultWriter{w: w, faults: faults, rand: rnd}
+}
+
+func (fw *faultWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	n := len(p)
+	if fw.faults.NULPadding > 0 {
+		p = padNUL(p, fw.faults.NULPadding)
+	}
+
+	disconnect := false
+	if fw.faults.DisconnectAfter > 0 && fw.written+len(p) >= fw.faults.DisconnectAfter {
+		p = p[:fw.faults.DisconnectAfter-fw.written]
+		disconnect = true
+	}
+
+	for len(p) > 0 {
+		chunk := p
+		if fw.faults.SplitSize > 0 {
+			size := 1 + fw.rand.Intn(fw.faults.SplitSize)
+			if size < len(chunk) {
+				chunk = chunk[:size]
+			}
+		}
+		if fw.faults.Latency > 0 {
+			time.Sleep(time.Duration(fw.rand.Int63n(int64(fw.faults.Latency))))
+		}
+
+		if _, err := fw.w.Write(chunk); err != nil {
+			return 0, err
+		}
+		fw.written += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	if disconnect {
+		return 0, ErrInjectedDisconnect
+	}
+	return n, nil
+}
+
+func padNUL(p []byte, count int) []byte {
+	if bytes.IndexByte(p, '\n') < 0 {
+		return p
+	}
+	padding := make([]byte, count)
+
+	var buf bytes.Buffer
+	for {
+		idx := bytes.IndexByte(p, '\n')
+		if idx < 0 {
+			buf.Write(p)
+			return buf.Bytes()
+		}
+		buf.Write(p[:idx+1])
+		buf.Write(padding)
+		p = p[idx+1:]
+	}
+}
@@ -0,0 +1,216 @@
+package sim
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Personality 以声明的方式描述一种设备的行为, 如各模式的提示符、命令的输出、
+// 分页的方式和错误提示等, 可以用 Apply 将它应用到 Options 上
+type Personality struct {
+	Name          string `json:"name"`
+	Welcome       string `json:"welcome,omitempty"`
+	UserQuest     string `json:"user_quest,omitempty"`
+	PasswordQuest string `json:"password_quest,omitempty"`
+
+	// LoginQuest 为登录后出现的提问, 如 "Change now? [Y/N]:", 回答 LoginAnswer 后才显示提示符
+	LoginQuest  string `json:"login_quest,omitempty"`
+	LoginAnswer string `json:"login_answer,omitempty"`
+
+	// Modes 为设备的各个模式, 第一个为登录后的模式, 后一个模式由前一个模式中执行 Command 进入
+	Modes []Mode `json:"modes"`
+
+	// More 为分页的提示, PageSize 为每页的行数, 为 0 时不分页
+	More      string `json:"more,omitempty"`
+	MoreAfter string `json:"more_after,omitempty"`
+	PageSize  int    `json:"page_size,omitempty"`
+
+	// InvalidCommand 为未知命令的错误提示, 其中的 {cmd} 会被替换为命令行
+	InvalidCommand string `json:"invalid_command,omitempty"`
+}
+
+// Mode 为设备的一个模式(视图)
+type Mode struct {
+	Name   string `json:"name"`
+	Prompt string `json:"prompt"`
+
+	// 从上一个模式进入本模式的命令, 密码为空或 <<none>> 时不需要密码
+	Command       string `json:"command,omitempty"`
+	PasswordQuest string `json:"password_quest,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Response      string `json:"response,omitempty"`
+
+	// Exit 为退回上一个模式的命令, exit 和 quit 总是可用的
+	Exit []string `json:"exit,omitempty"`
+
+	// Commands 为命令到输出的映射, 命令可以有多个词, 如 "show running-config"
+	Commands map[string]Output `json:"commands,omitempty"`
+}
+
+// Output 为一个命令的输出
+type Output struct {
+	Text string `json:"text,omitempty"`
+
+	// Question 不为空时先输出 Text 再提问, 回答 y 时输出 Yes, 否则输出 No
+	Question string `json:"question,omitempty"`
+	Yes      string `json:"yes,omitempty"`
+	No       string `json:"no,omitempty"`
+}
+
+// ReadPersonality 从 json 中读一个 Personality
+func ReadPersonality(r io.Reader) (*Personality, error) {
+	var p Personality
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Apply 将 Personality 应用到 options 上, 用户和密码仍需要另外设置
+func (p *Personality) Apply(options *Options) {
+	if p.Welcome != "" {
+		options.Welcome = []byte(p.Welcome)
+	}
+	if p.UserQuest != "" || p.PasswordQuest != "" {
+		options.SetUserQuest([]byte(p.UserQuest), []byte(p.PasswordQuest))
+	}
+	options.InvalidCommand = p.InvalidCommand
+
+	if len(p.Modes) == 0 {
+		options.WithPrompt([]byte("#"), Echo)
+		return
+	}
+
+	handler := p.handler(0)
+	if p.LoginQuest != "" {
+		handler = WithQuest(p.LoginQuest, p.LoginAnswer, handler)
+	}
+	options.WithPrompt([]byte(p.Modes[0].Prompt), handler)
+}
+
+func (p *Personality) handler(idx int) Handler {
+	mode := p.Modes[idx]
+
+	root := &commandNode{}
+	for name, output := range mode.Commands {
+		root.add(strings.Fields(name), p.output(output))
+	}
+
+	for _, name := range mode.Exit {
+		root.add(strings.Fields(name), func(*Session, []byte, []byte) error {
+			return ErrExit
+		})
+	}
+
+	if idx+1 < len(p.Modes) {
+		next := p.Modes[idx+1]
+		password := next.Password
+		if password == "" {
+			password = string(noneValue)
+		}
+		root.add(strings.Fields(next.Command),
+			EnterMode(next.PasswordQuest, password, next.Response, next.Prompt, p.handler(idx+1)))
+	}
+	return OS(root.commands())
+}
+
+func (p *Personality) output(output Output) Command {
+	var pages []string
+	if output.Text != "" {
+		pages = p.paginate(output.Text)
+	}
+	mores := make([][]byte, len(pages))
+	for idx := range mores {
+		mores[idx] = []byte(p.More)
+	}
+
+	return func(s *Session, line, args []byte) error {
+		if err := s.WritePages(pages, mores, []byte(p.MoreAfter)); err != nil {
+			return err
+		}
+		if output.Question == "" {
+			return nil
+		}
+
+		if err := s.WriteString(output.Question); err != nil {
+			return err
+		}
+		answer, err := s.ReadLine()
+		if err != nil {
+			return err
+		}
+		answer = []byte(strings.TrimSpace(string(answer)))
+		if len(answer) > 0 && (answer[0] == 'y' || answer[0] == 'Y') {
+			if output.Yes != "" {
+				return s.WriteLine([]byte(output.Yes))
+			}
+		} else if output.No != "" {
+			return s.WriteLine([]byte(output.No))
+		}
+		return nil
+	}
+}
+
+func (p *Personality) paginate(text string) []string {
+	text = strings.TrimRight(text, "\r\n")
+	if p.PageSize <= 0 || p.More == "" {
+		return []string{text}
+	}
+
+	lines := strings.Split(text, "\n")
+	var pages []string
+	for len(lines) > p.PageSize {
+		pages = append(pages, strings.Join(lines[:p.PageSize], "\n"))
+		lines = lines[p.PageSize:]
+	}
+	return append(pages, strings.Join(lines, "\n"))
+}
+
+// commandNode 用于将多个词的命令转换为嵌套的 Commands
+type commandNode struct {
+	cmd      Command
+	children map[string]*commandNode
+}
+
+func (node *commandNode) add(words []string, cmd Command) {
+	if len(words) == 0 {
+		node.cmd = cmd
+		return
+	}
+	if node.children == nil {
+		node.children = map[string]*commandNode{}
+	}
+	child := node.children[words[0]]
+	if child == nil {
+		child = &commandNode{}
+		node.children[words[0]] = child
+	}
+	child.add(words[1:], cmd)
+}
+
+func (node *commandNode) commands() Commands {
+	commands := Commands{}
+	for name, child := range node.children {
+		commands[name] = child.command()
+	}
+	return commands
+}
+
+func (node *commandNode) command() Command {
+	if len(node.children) == 0 {
+		return node.cmd
+	}
+
+	sub := WithCommands(node.commands())
+	if node.cmd == nil {
+		return sub
+	}
+	cmd := node.cmd
+	return func(s *Session, line, args []byte) error {
+		if len(args) == 0 {
+			return cmd(s, line, args)
+		}
+		return sub(s, line, args)
+	}
+}
@@ -0,0 +1,125 @@
+package personality
+
+import "github.com/mei-rune/shell/sim"
+
+// CiscoIOS 模拟一台 Cisco IOS 交换机
+func CiscoIOS() *sim.Personality {
+	return &sim.Personality{
+		Name:          "cisco",
+		Welcome:       "\r\nUser Access Verification\r\n\r\n",
+		UserQuest:     "Username: ",
+		PasswordQuest: "Password: ",
+		Modes: []sim.Mode{
+			{
+				Name:   "user",
+				Prompt: "Switch>",
+				Commands: map[string]sim.Output{
+					"show version": {Text: ciscoVersion},
+					"show running-config": {Text: lines(
+						"                         ^",
+						"% Invalid input detected at '^' marker.",
+						"")},
+				},
+			},
+			{
+				Name:          "privileged",
+				Prompt:        "Switch#",
+				Command:       "enable",
+				PasswordQuest: "Password: ",
+				Password:      DefaultPassword,
+				Commands: map[string]sim.Output{
+					"show version":        {Text: ciscoVersion},
+					"show running-config": {Text: ciscoRunningConfig},
+					"write memory":        {Text: "Building configuration...\r\n[OK]"},
+				},
+			},
+			{
+				Name:     "config",
+				Prompt:   "Switch(config)#",
+				Command:  "configure terminal",
+				Response: "Enter configuration commands, one per line.  End with CNTL/Z.",
+				Exit:     []string{"end"},
+			},
+		},
+		More:           " --More-- ",
+		MoreAfter:      "\b\b\b\b\b\b\b\b\b\b          \b\b\b\b\b\b\b\b\b\b",
+		PageSize:       24,
+		InvalidCommand: "                         ^\r\n% Invalid input detected at '^' marker.",
+	}
+}
+
+var ciscoVersion = lines(
+	"Cisco IOS Software, C2960 Software (C2960-LANBASEK9-M), Version 12.2(55)SE5, RELEASE SOFTWARE (fc1)",
+	"Technical Support: http://www.cisco.com/techsupport",
+	"Copyright (c) 1986-2012 by Cisco Systems, Inc.",
+	"Compiled Thu 09-Feb-12 18:42 by prod_rel_team",
+	"",
+	"ROM: Bootstrap program is C2960 boot loader",
+	"BOOTLDR: C2960 Boot Loader (C2960-HBOOT-M) Version 12.2(44)SE5, RELEASE SOFTWARE (fc1)",
+	"",
+	"Switch uptime is 2 weeks, 3 days, 4 hours, 5 minutes",
+	"System returned to ROM by power-on",
+	"System image file is \"flash:c2960-lanbasek9-mz.122-55.SE5.bin\"",
+	"",
+	"cisco WS-C2960-24TT-L (PowerPC405) processor (revision B0) with 65536K bytes of memory.",
+	"Processor board ID FOC1010X104",
+	"24 FastEthernet interfaces",
+	"2 Gigabit Ethernet interfaces",
+	"",
+	"Configuration register is 0xF")
+
+var ciscoRunningConfig = lines(
+	"Building configuration...",
+	"",
+	"Current configuration : 1520 bytes",
+	"!",
+	"version 12.2",
+	"no service pad",
+	"service timestamps debug datetime msec",
+	"service timestamps log datetime msec",
+	"no service password-encryption",
+	"!",
+	"hostname Switch",
+	"!",
+	"boot-start-marker",
+	"boot-end-marker",
+	"!",
+	"enable secret 5 $1$Yq5S$dpYgPVp4aHo8a.5tXt4Cb/",
+	"!",
+	"username admin privilege 15 password 0 admin",
+	"no aaa new-model",
+	"system mtu routing 1500",
+	"ip subnet-zero",
+	"!",
+	"spanning-tree mode pvst",
+	"spanning-tree extend system-id",
+	"!",
+	"vlan internal allocation policy ascending",
+	"!",
+	"interface FastEthernet0/1",
+	"!",
+	"interface FastEthernet0/2",
+	"!",
+	"interface FastEthernet0/3",
+	"!",
+	"interface FastEthernet0/4",
+	"!",
+	"interface GigabitEthernet0/1",
+	"!",
+	"interface GigabitEthernet0/2",
+	"!",
+	"interface Vlan1",
+	" ip address 192.168.1.1 255.255.255.0",
+	" no ip route-cache",
+	"!",
+	"ip http server",
+	"ip http secure-server",
+	"snmp-server community public RO",
+	"!",
+	"line con 0",
+	"line vty 0 4",
+	" login local",
+	"line vty 5 15",
+	" login local",
+	"!",
+	"end")
@@ -0,0 +1,107 @@
+package personality
+
+import "github.com/mei-rune/shell/sim"
+
+// FortiOS 模拟一台 FortiGate 防火墙, 它没有 enable, 用 end 退出 config 模式
+func FortiOS() *sim.Personality {
+	return &sim.Personality{
+		Name:          "fortios",
+		UserQuest:     "FGT60E login: ",
+		PasswordQuest: "Password: ",
+		Modes: []sim.Mode{
+			{
+				Name:   "global",
+				Prompt: "FGT60E # ",
+				Commands: map[string]sim.Output{
+					"get system status":       {Text: fortiosStatus},
+					"show full-configuration": {Text: fortiosConfiguration},
+					"show":                    {Text: fortiosConfiguration},
+				},
+			},
+			{
+				Name:    "system global",
+				Prompt:  "FGT60E (global) # ",
+				Command: "config system global",
+				Exit:    []string{"end", "abort"},
+				Commands: map[string]sim.Output{
+					"show": {Text: lines(
+						"config system global",
+						"    set alias \"FGT60E\"",
+						"    set hostname \"FGT60E\"",
+						"    set timezone 55",
+						"end")},
+				},
+			},
+		},
+		More:           "--More-- ",
+		MoreAfter:      "\r         \r",
+		PageSize:       24,
+		InvalidCommand: "Unknown command\r\nCommand fail. Return code -61",
+	}
+}
+
+var fortiosStatus = lines(
+	"Version: FortiGate-60E v6.0.4,build0231,190107 (GA)",
+	"Virus-DB: 1.00000(2018-04-09 18:07)",
+	"IPS-DB: 6.00741(2015-12-01 02:30)",
+	"Serial-Number: FGT60E4Q16000000",
+	"BIOS version: 05000010",
+	"Log hard disk: Not available",
+	"Hostname: FGT60E",
+	"Operation Mode: NAT",
+	"Current virtual domain: root",
+	"Max number of virtual domains: 10",
+	"Virtual domains status: 1 in NAT mode, 0 in TP mode",
+	"Virtual domain configuration: disable",
+	"FIPS-CC mode: disable",
+	"Current HA mode: standalone",
+	"Branch point: 0231",
+	"Release Version Information: GA",
+	"System time: Tue Apr  7 10:11:21 2020")
+
+var fortiosConfiguration = lines(
+	"#config-version=FGT60E-6.0.4-FW-build0231-190107:opmode=0:vdom=0:user=admin",
+	"#conf_file_ver=1234567890",
+	"#buildno=0231",
+	"#global_vdom=1",
+	"config system global",
+	"    set alias \"FGT60E\"",
+	"    set hostname \"FGT60E\"",
+	"    set timezone 55",
+	"end",
+	"config system accprofile",
+	"    edit \"prof_admin\"",
+	"        set secfabgrp read-write",
+	"        set ftviewgrp read-write",
+	"        set authgrp read-write",
+	"        set sysgrp read-write",
+	"        set netgrp read-write",
+	"        set loggrp read-write",
+	"        set fwgrp read-write",
+	"        set vpngrp read-write",
+	"        set utmgrp read-write",
+	"        set wifi read-write",
+	"    next",
+	"end",
+	"config system interface",
+	"    edit \"wan1\"",
+	"        set vdom \"root\"",
+	"        set ip 192.168.1.5 255.255.255.0",
+	"        set allowaccess ping https ssh telnet",
+	"        set type physical",
+	"        set role wan",
+	"    next",
+	"    edit \"internal\"",
+	"        set vdom \"root\"",
+	"        set ip 192.168.100.99 255.255.255.0",
+	"        set allowaccess ping https ssh http fgfm capwap",
+	"        set type hard-switch",
+	"        set role lan",
+	"    next",
+	"end",
+	"config system admin",
+	"    edit \"admin\"",
+	"        set accprofile \"super_admin\"",
+	"        set vdom \"root\"",
+	"    next",
+	"end")
@@ -0,0 +1,133 @@
+package personality
+
+import "github.com/mei-rune/shell/sim"
+
+// H3CComware 模拟一台 H3C Comware 交换机, super 之后才能进入 system-view
+func H3CComware() *sim.Personality {
+	return &sim.Personality{
+		Name: "h3c",
+		Welcome: lines(
+			"******************************************************************************",
+			"* Copyright (c) 2004-2017 New H3C Technologies Co., Ltd. All rights reserved.*",
+			"* Without the owner's prior written consent,                                 *",
+			"* no decompiling or reverse-engineering shall be allowed.                    *",
+			"******************************************************************************",
+			"",
+			""),
+		UserQuest:     "Username:",
+		PasswordQuest: "Password:",
+		Modes: []sim.Mode{
+			{
+				Name:   "user",
+				Prompt: "<H3C>",
+				Commands: map[string]sim.Output{
+					"display version": {Text: h3cVersion},
+				},
+			},
+			{
+				Name:          "super",
+				Prompt:        "<H3C>",
+				Command:       "super",
+				PasswordQuest: "Password:",
+				Password:      DefaultPassword,
+				Response: lines(
+					"User privilege level is 3, and only those commands can be used",
+					"whose level is equal or less than this.",
+					"Privilege note: 0-VISIT, 1-MONITOR, 2-SYSTEM, 3-MANAGE"),
+				Commands: map[string]sim.Output{
+					"display version":               {Text: h3cVersion},
+					"display current-configuration": {Text: h3cCurrentConfiguration},
+					"save": {
+						Question: "The current configuration will be written to the device. Are you sure? [Y/N]:",
+						Yes: lines(
+							"Please input the file name(*.cfg)[flash:/startup.cfg]",
+							"Validating file. Please wait...",
+							"Saved the current configuration to mainboard device successfully."),
+						No: "Save operation has been cancelled.",
+					},
+				},
+			},
+			{
+				Name:     "system",
+				Prompt:   "[H3C]",
+				Command:  "system-view",
+				Response: "System View: return to User View with Ctrl+Z.",
+				Exit:     []string{"return"},
+				Commands: map[string]sim.Output{
+					"display current-configuration": {Text: h3cCurrentConfiguration},
+				},
+			},
+		},
+		More:           "  ---- More ----",
+		MoreAfter:      "\x1b[16D                \x1b[16D",
+		PageSize:       24,
+		InvalidCommand: "            ^\r\n % Unrecognized command found at '^' position.",
+	}
+}
+
+var h3cVersion = lines(
+	"H3C Comware Software, Version 7.1.045, Release 2418P06",
+	"Copyright (c) 2004-2017 New H3C Technologies Co., Ltd. All rights reserved.",
+	"H3C S5130-28S-EI uptime is 0 weeks, 2 days, 3 hours, 4 minutes",
+	"Last reboot reason : Cold reboot",
+	"",
+	"Boot image: flash:/s5130ei-cmw710-boot-r2418p06.bin",
+	"Boot image version: 7.1.045, Release 2418P06",
+	"System image: flash:/s5130ei-cmw710-system-r2418p06.bin",
+	"System image version: 7.1.045, Release 2418P06")
+
+var h3cCurrentConfiguration = lines(
+	"#",
+	" version 7.1.045, Release 2418P06",
+	"#",
+	" sysname H3C",
+	"#",
+	" irf mac-address persistent timer",
+	" irf auto-update enable",
+	" undo irf link-delay",
+	" irf member 1 priority 1",
+	"#",
+	" lldp global enable",
+	"#",
+	" password-recovery enable",
+	"#",
+	"vlan 1",
+	"#",
+	" stp global enable",
+	"#",
+	"interface NULL0",
+	"#",
+	"interface Vlan-interface1",
+	" ip address 192.168.1.2 255.255.255.0",
+	"#",
+	"interface GigabitEthernet1/0/1",
+	" port link-mode bridge",
+	" combo enable fiber",
+	"#",
+	"interface GigabitEthernet1/0/2",
+	" port link-mode bridge",
+	"#",
+	"interface GigabitEthernet1/0/3",
+	" port link-mode bridge",
+	"#",
+	" scheduler logfile size 16",
+	"#",
+	"line class aux",
+	" user-role network-admin",
+	"#",
+	"line class vty",
+	" user-role network-operator",
+	"#",
+	"line vty 0 63",
+	" authentication-mode scheme",
+	" user-role network-operator",
+	"#",
+	" snmp-agent",
+	" snmp-agent community read public",
+	" snmp-agent sys-info version v2c v3",
+	"#",
+	"local-user admin class manage",
+	" service-type telnet ssh",
+	" authorization-attribute user-role network-admin",
+	"#",
+	"return")
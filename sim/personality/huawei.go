@@ -0,0 +1,113 @@
+package personality
+
+import "github.com/mei-rune/shell/sim"
+
+// HuaweiVRP 模拟一台华为 VRP 交换机, 登录后会提示修改密码
+func HuaweiVRP() *sim.Personality {
+	return &sim.Personality{
+		Name: "huawei",
+		Welcome: lines(
+			"",
+			"Warning: Telnet is not a secure protocol, and it is recommended to use Stelnet.",
+			"",
+			""),
+		UserQuest:     "Username:",
+		PasswordQuest: "Password:",
+		LoginQuest: lines(
+			"Info: The max number of VTY users is 5, and the number",
+			"      of current VTY users on line is 1.",
+			"      The current login time is 2020-04-07 10:11:21.",
+			"Info: The password needs to be changed. Change now? [Y/N]:"),
+		LoginAnswer: "N",
+		Modes: []sim.Mode{
+			{
+				Name:   "user",
+				Prompt: "<HUAWEI>",
+				Commands: map[string]sim.Output{
+					"display version":               {Text: huaweiVersion},
+					"display current-configuration": {Text: huaweiCurrentConfiguration},
+					"save": {
+						Question: "Warning: The current configuration will be written to the device. Continue? [Y/N]:",
+						Yes: lines(
+							"Now saving the current configuration to the slot 0.",
+							"Info: Save the configuration successfully."),
+					},
+				},
+			},
+			{
+				Name:     "system",
+				Prompt:   "[HUAWEI]",
+				Command:  "system-view",
+				Response: "Enter system view, return user view with Ctrl+Z.",
+				Exit:     []string{"return"},
+				Commands: map[string]sim.Output{
+					"display current-configuration": {Text: huaweiCurrentConfiguration},
+				},
+			},
+		},
+		More:           "  ---- More ----",
+		MoreAfter:      "\x1b[42D                                          \x1b[42D",
+		PageSize:       24,
+		InvalidCommand: "            ^\r\nError: Unrecognized command found at '^' position.",
+	}
+}
+
+var huaweiVersion = lines(
+	"Huawei Versatile Routing Platform Software",
+	"VRP (R) software, Version 5.170 (S5720 V200R011C10SPC500)",
+	"Copyright (C) 2000-2018 HUAWEI TECH Co., Ltd.",
+	"HUAWEI S5720-28X-SI-AC Routing Switch uptime is 0 week, 2 days, 3 hours, 4 minutes")
+
+var huaweiCurrentConfiguration = lines(
+	"!Software Version V200R011C10SPC500",
+	"#",
+	"sysname HUAWEI",
+	"#",
+	"vlan batch 10 20",
+	"#",
+	"cluster enable",
+	"ntdp enable",
+	"ndp enable",
+	"#",
+	"drop illegal-mac alarm",
+	"#",
+	"diffserv domain default",
+	"#",
+	"aaa",
+	" authentication-scheme default",
+	" authorization-scheme default",
+	" accounting-scheme default",
+	" domain default",
+	" domain default_admin",
+	" local-user admin password irreversible-cipher %^%#qPj.(Q<7b=Hk2/9H^mX6(%^%#",
+	" local-user admin privilege level 15",
+	" local-user admin service-type telnet ssh",
+	"#",
+	"interface Vlanif1",
+	" ip address 192.168.1.3 255.255.255.0",
+	"#",
+	"interface MEth0/0/1",
+	"#",
+	"interface GigabitEthernet0/0/1",
+	" port link-type access",
+	" port default vlan 10",
+	"#",
+	"interface GigabitEthernet0/0/2",
+	" port link-type access",
+	" port default vlan 20",
+	"#",
+	"interface NULL0",
+	"#",
+	"snmp-agent",
+	"snmp-agent community read cipher %^%#5tTy:3Q9$PBNq)W(Z\"y;=)yZ7%^%#",
+	"snmp-agent sys-info version v2c v3",
+	"#",
+	"stelnet server enable",
+	"#",
+	"user-interface con 0",
+	" authentication-mode password",
+	"user-interface vty 0 4",
+	" authentication-mode aaa",
+	" protocol inbound all",
+	"#",
+	"return")
@@ -0,0 +1,80 @@
+package personality
+
+import "github.com/mei-rune/shell/sim"
+
+// Junos 模拟一台 Juniper 路由器
+//
+// 真实设备的分页提示为 "---(more)---", 它不在 shell.MorePrompts 中, 一般会先执行
+// "set cli screen-length 0" 来关闭分页, 所以这里也不分页
+func Junos() *sim.Personality {
+	return &sim.Personality{
+		Name:          "junos",
+		Welcome:       "\r\n",
+		UserQuest:     "login: ",
+		PasswordQuest: "Password:",
+		Modes: []sim.Mode{
+			{
+				Name:   "operational",
+				Prompt: "admin@router> ",
+				Commands: map[string]sim.Output{
+					"show version":                   {Text: junosVersion},
+					"show configuration":             {Text: junosConfiguration},
+					"set cli screen-length 0":        {Text: "Screen length set to 0"},
+					"show interfaces terse ge-0/0/0": {Text: junosInterfaces},
+				},
+			},
+			{
+				Name:     "configuration",
+				Prompt:   "admin@router# ",
+				Command:  "configure",
+				Response: "Entering configuration mode\r\n\r\n[edit]",
+				Commands: map[string]sim.Output{
+					"show":   {Text: junosConfiguration},
+					"commit": {Text: "commit complete"},
+				},
+			},
+		},
+		InvalidCommand: "                   ^\r\nUnknown command.",
+	}
+}
+
+var junosVersion = lines(
+	"Hostname: router",
+	"Model: mx480",
+	"Junos: 17.3R3.10",
+	"JUNOS OS Kernel 64-bit  [20180816.8630ec5_builder_stable_11]",
+	"JUNOS OS libs [20180816.8630ec5_builder_stable_11]",
+	"JUNOS OS runtime [20180816.8630ec5_builder_stable_11]")
+
+var junosInterfaces = lines(
+	"Interface               Admin Link Proto    Local                 Remote",
+	"ge-0/0/0                up    up",
+	"ge-0/0/0.0              up    up   inet     192.168.1.4/24")
+
+var junosConfiguration = lines(
+	"## Last commit: 2020-04-07 10:11:21 UTC by admin",
+	"version 17.3R3.10;",
+	"system {",
+	"    host-name router;",
+	"    root-authentication {",
+	"        encrypted-password \"$6$EbT1Bq3x$gQ0W8pDFo1XQ.\"; ## SECRET-DATA",
+	"    }",
+	"    login {",
+	"        user admin {",
+	"            class super-user;",
+	"        }",
+	"    }",
+	"    services {",
+	"        ssh;",
+	"        telnet;",
+	"    }",
+	"}",
+	"interfaces {",
+	"    ge-0/0/0 {",
+	"        unit 0 {",
+	"            family inet {",
+	"                address 192.168.1.4/24;",
+	"            }",
+	"        }",
+	"    }",
+	"}")
@@ -0,0 +1,41 @@
+package personality
+
+import "github.com/mei-rune/shell/sim"
+
+// LinuxBash 模拟一台 Linux 主机上的 bash, 用 "su -" 切换为 root
+func LinuxBash() *sim.Personality {
+	return &sim.Personality{
+		Name:          "linux",
+		Welcome:       "CentOS Linux 7 (Core)\r\nKernel 3.10.0-957.el7.x86_64 on an x86_64\r\n\r\n",
+		UserQuest:     "localhost login: ",
+		PasswordQuest: "Password: ",
+		Modes: []sim.Mode{
+			{
+				Name:   "user",
+				Prompt: "[admin@localhost ~]$ ",
+				Commands: map[string]sim.Output{
+					"uname -a": {Text: linuxUname},
+					"id":       {Text: "uid=1000(admin) gid=1000(admin) groups=1000(admin),10(wheel)"},
+					"hostname": {Text: "localhost"},
+				},
+			},
+			{
+				Name:          "root",
+				Prompt:        "[root@localhost ~]# ",
+				Command:       "su -",
+				PasswordQuest: "Password: ",
+				Password:      DefaultPassword,
+				Exit:          []string{"logout"},
+				Commands: map[string]sim.Output{
+					"uname -a":          {Text: linuxUname},
+					"id":                {Text: "uid=0(root) gid=0(root) groups=0(root)"},
+					"hostname":          {Text: "localhost"},
+					"cat /etc/hostname": {Text: "localhost"},
+				},
+			},
+		},
+		InvalidCommand: "-bash: {cmd}: Unknown command",
+	}
+}
+
+var linuxUname = "Linux localhost 3.10.0-957.el7.x86_64 #1 SMP Thu Nov 8 23:39:32 UTC 2018 x86_64 x86_64 x86_64 GNU/Linux"
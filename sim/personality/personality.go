@@ -0,0 +1,45 @@
+// Package personality 为常见设备预置了 sim.Personality, 可以用来模拟这些设备
+//
+//	options := &telnetd.Options{}
+//	options.AddUserPassword("admin", "admin")
+//	personality.CiscoIOS().Apply(options)
+//
+// 需要密码的模式(如 enable)的密码都为 DefaultPassword
+package personality
+
+import (
+	"strings"
+
+	"github.com/mei-rune/shell/sim"
+)
+
+// DefaultPassword 为预置设备中 enable 等模式的密码
+const DefaultPassword = "admin"
+
+var all = map[string]func() *sim.Personality{
+	"cisco":   CiscoIOS,
+	"h3c":     H3CComware,
+	"huawei":  HuaweiVRP,
+	"junos":   Junos,
+	"fortios": FortiOS,
+	"linux":   LinuxBash,
+}
+
+// Names 返回所有预置设备的名称
+func Names() []string {
+	return []string{"cisco", "h3c", "huawei", "junos", "fortios", "linux"}
+}
+
+// Lookup 按名称查找预置的设备, 每次都返回一个新的副本, 可以随意修改
+func Lookup(name string) (*sim.Personality, bool) {
+	fn, ok := all[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	return fn(), true
+}
+
+// lines 用 \r\n 将多行文本连接起来
+func lines(ss ...string) string {
+	return strings.Join(ss, "\r\n")
+}
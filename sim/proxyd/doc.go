@@ -0,0 +1,2 @@
+// Package proxyd 是一个 SOCKS5 和 HTTP CONNECT 代理, 用于测试
+package proxyd
@@ -0,0 +1,195 @@
+package proxyd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/mei-rune/shell/sim"
+)
+
+type Server = sim.Server
+
+// Options 为代理的设置
+type Options struct {
+	// Username 不为空时要求认证
+	Username string
+	Password string
+	// Hosts 将目标的主机名映射为实际的地址, 用于在测试中模拟不能直接解析的主机
+	Hosts map[string]string
+}
+
+func (options *Options) target(host, port string) string {
+	if addr, ok := options.Hosts[host]; ok {
+		host = addr
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// StartSOCKS5 启动一个 SOCKS5 代理(只支持 CONNECT), addr 为 ":" 时随机选一个端口
+func StartSOCKS5(addr string, options *Options) (*Server, error) {
+	return sim.Listen(addr, func(conn net.Conn) {
+		serveSOCKS5(conn, options)
+	})
+}
+
+func serveSOCKS5(conn net.Conn, options *Options) {
+	r := bufio.NewReader(conn)
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil || head[0] != 5 {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return
+	}
+	want := byte(0)
+	if options.Username != "" {
+		want = 2
+	}
+	found := false
+	for _, method := range methods {
+		found = found || method == want
+	}
+	if !found {
+		conn.Write([]byte{5, 0xff})
+		return
+	}
+	conn.Write([]byte{5, want})
+
+	if want == 2 {
+		username, password, err := readUserPassword(r)
+		if err != nil {
+			return
+		}
+		if username != options.Username || password != options.Password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make([]byte, 4)
+		if req[3] == 4 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return
+	}
+	if req[1] != 1 {
+		conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	target, err := net.Dial("tcp", options.target(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(conn, r, target)
+}
+
+func readUserPassword(r *bufio.Reader) (string, string, error) {
+	readString := func() (string, error) {
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		bs := make([]byte, n)
+		_, err = io.ReadFull(r, bs)
+		return string(bs), err
+	}
+	if _, err := r.ReadByte(); err != nil {
+		return "", "", err
+	}
+	username, err := readString()
+	if err != nil {
+		return "", "", err
+	}
+	password, err := readString()
+	return username, password, err
+}
+
+// StartHTTPConnect 启动一个 HTTP 代理(只支持 CONNECT), addr 为 ":" 时随机选一个端口
+func StartHTTPConnect(addr string, options *Options) (*Server, error) {
+	return sim.Listen(addr, func(conn net.Conn) {
+		serveHTTPConnect(conn, options)
+	})
+}
+
+func serveHTTPConnect(conn net.Conn, options *Options) {
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	if options.Username != "" {
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(options.Username+":"+options.Password))
+		if req.Header.Get("Proxy-Authorization") != auth {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"proxyd\"\r\n\r\n")
+			return
+		}
+	}
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", options.target(host, port))
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	pipe(conn, r, target)
+}
+
+// pipe 在 conn 和 target 之间转发数据, r 为 conn 的带缓冲的读取器
+func pipe(conn net.Conn, r io.Reader, target net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(conn, target)
+		conn.Close()
+		close(done)
+	}()
+	io.Copy(target, r)
+	target.Close()
+	<-done
+}
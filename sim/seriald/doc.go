@@ -0,0 +1,2 @@
+// Package seriald 用伪终端模拟一个网络设备的 console 口, 用于测试串口的连接
+package seriald
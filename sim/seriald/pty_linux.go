@@ -0,0 +1,84 @@
+package seriald
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty 创建一对伪终端, 同时打开从设备并设为 raw 模式, 这样客户端关闭串口后主设备不会读到 EIO,
+// 客户端打开串口前写入的数据也不会被行规程处理
+func openPty() (master, slave *os.File, port string, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer func() {
+		if err != nil {
+			master.Close()
+		}
+	}()
+
+	// 注意不能调用 master.Fd(), 它会将文件设为阻塞模式, 这样 Close 时无法中断 Read
+	rawConn, err := master.SyscallConn()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var n int
+	cerr := rawConn.Control(func(fd uintptr) {
+		if err = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); err != nil {
+			return
+		}
+		n, err = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	})
+	if cerr != nil {
+		return nil, nil, "", cerr
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	port = "/dev/pts/" + strconv.Itoa(n)
+	slave, err = os.OpenFile(port, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	rawConn, err = slave.SyscallConn()
+	if err == nil {
+		cerr = rawConn.Control(func(fd uintptr) {
+			err = makeRaw(int(fd))
+		})
+		if cerr != nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slave.Close()
+		return nil, nil, "", err
+	}
+	return master, slave, port, nil
+}
+
+func makeRaw(fd int) error {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+}
+
+// isClosed 判断是否为伪终端关闭后的错误
+func isClosed(err error) bool {
+	return errors.Is(err, os.ErrClosed) || errors.Is(err, syscall.EIO)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package seriald
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+func openPty() (master, slave *os.File, port string, err error) {
+	return nil, nil, "", errors.New("pty is unsupported on " + runtime.GOOS)
+}
+
+func isClosed(err error) bool {
+	return errors.Is(err, os.ErrClosed)
+}
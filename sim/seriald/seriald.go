@@ -0,0 +1,96 @@
+package seriald
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mei-rune/shell/sim"
+)
+
+type (
+	Options  = sim.Options
+	Session  = sim.Session
+	Handler  = sim.Handler
+	Command  = sim.Command
+	Commands = sim.Commands
+)
+
+var (
+	Echo           = sim.Echo
+	OS             = sim.OS
+	WithEnable     = sim.WithEnable
+	WithSystemView = sim.WithSystemView
+	WithQuest      = sim.WithQuest
+	WithCommands   = sim.WithCommands
+	WithMore       = sim.WithMore
+	WithMoreArray  = sim.WithMoreArray
+)
+
+// Server 是一个模拟的 console 口, 客户端打开 Port() 返回的串口设备即可连接
+type Server struct {
+	master *os.File
+	slave  *os.File
+	port   string
+
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// StartServer 创建一对伪终端, 在主设备上模拟设备的 console 口
+func StartServer(options *Options) (*Server, error) {
+	master, slave, port, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		master: master,
+		slave:  slave,
+		port:   port,
+	}
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		srv.serve(options)
+	}()
+	return srv, nil
+}
+
+// serve 和真实的 console 口一样, 收到按键后才显示登录提示, 会话结束后等待下一次按键.
+// 客户端关闭串口并不会结束会话, 下一个打开串口的客户端会接着使用同一个会话
+func (srv *Server) serve(options *Options) {
+	r := bufio.NewReader(srv.master)
+	for {
+		session := sim.NewSession(r, srv.master, options)
+		if _, err := session.ReadKey(); err != nil {
+			return
+		}
+		if err := session.WriteString("\r\n"); err != nil {
+			return
+		}
+		if err := session.Serve(true); err == io.EOF || isClosed(err) {
+			return
+		}
+		if err := session.WriteString("\r\n"); err != nil {
+			return
+		}
+	}
+}
+
+// Port 返回串口设备(伪终端从设备)的路径
+func (srv *Server) Port() string {
+	return srv.port
+}
+
+// Close 关闭伪终端
+func (srv *Server) Close() error {
+	var err error
+	srv.closeOnce.Do(func() {
+		err = srv.master.Close()
+		srv.slave.Close()
+	})
+	srv.wg.Wait()
+	return err
+}
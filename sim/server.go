@@ -0,0 +1,98 @@
+package sim
+
+import (
+	"net"
+	"sync"
+)
+
+// Server 是模拟设备的监听器
+type Server struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Listen 在 addr 上监听, 每一个新连接都会在一个新的 goroutine 中调用 handle
+func Listen(addr string, handle func(net.Conn)) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		listener: listener,
+		conns:    map[net.Conn]struct{}{},
+	}
+	srv.wg.Add(1)
+	go srv.serve(handle)
+	return srv, nil
+}
+
+func (srv *Server) serve(handle func(net.Conn)) {
+	defer srv.wg.Done()
+
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		srv.mu.Lock()
+		if srv.closed {
+			srv.mu.Unlock()
+			conn.Close()
+			return
+		}
+		srv.conns[conn] = struct{}{}
+		srv.wg.Add(1)
+		srv.mu.Unlock()
+
+		go func() {
+			defer func() {
+				conn.Close()
+
+				srv.mu.Lock()
+				delete(srv.conns, conn)
+				srv.mu.Unlock()
+				srv.wg.Done()
+			}()
+
+			handle(conn)
+		}()
+	}
+}
+
+// Addr 返回监听的地址
+func (srv *Server) Addr() net.Addr {
+	return srv.listener.Addr()
+}
+
+// Port 返回监听的端口
+func (srv *Server) Port() string {
+	_, port, _ := net.SplitHostPort(srv.listener.Addr().String())
+	return port
+}
+
+// Connections 返回当前的连接数
+func (srv *Server) Connections() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.conns)
+}
+
+// Close 停止监听并关闭所有的连接
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	err := srv.listener.Close()
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.mu.Unlock()
+
+	srv.wg.Wait()
+	return err
+}
@@ -0,0 +1,385 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+// ErrExit 由命令返回，表示退出当前的模式(视图)
+var ErrExit = errors.New("exit")
+
+// ExitStatus 由命令返回，结束整个会话, sshd 将它作为 exit-status 或 exit-signal 发给客户端
+type ExitStatus struct {
+	Code   int
+	Signal string
+}
+
+func (e *ExitStatus) Error() string {
+	if e.Signal != "" {
+		return "exit signal " + e.Signal
+	}
+	return "exit status " + strconv.Itoa(e.Code)
+}
+
+var (
+	noneValue  = []byte("<<none>>")
+	emptyValue = []byte("<<empty>>")
+)
+
+// IsNone 判断用户名或密码是否为 <<none>>, 即不需要输入
+func IsNone(s string) bool {
+	return s == string(noneValue)
+}
+
+func isEmpty(s string) bool {
+	return s == string(emptyValue)
+}
+
+// Session 是一个模拟设备上的会话，它与具体的传输协议无关
+type Session struct {
+	r       *bufio.Reader
+	w       io.Writer
+	wmu     sync.Mutex
+	options *Options
+
+	faults *Faults
+	rand   *rand.Rand
+
+	skipLF bool
+	line   []byte
+
+	termMu     sync.Mutex
+	term       string
+	cols, rows int
+
+	Username string
+}
+
+// NewSession 创建一个会话, r 中读到的是已去掉协议控制字符的用户输入
+func NewSession(r io.Reader, w io.Writer, options *Options) *Session {
+	if options == nil {
+		options = &Options{}
+	}
+	s := &Session{
+		r:       bufio.NewReader(r),
+		w:       w,
+		options: options,
+	}
+	if options.Faults != nil {
+		s.faults = options.Faults
+		s.rand = options.Faults.random()
+		s.w = newFaultWriter(w, options.Faults, options.Faults.random())
+	}
+	return s
+}
+
+func (s *Session) dropMore() bool {
+	return s.faults != nil && s.faults.DropMore > 0 && s.rand.Float64() < s.faults.DropMore
+}
+
+// Options 返回会话的配置
+func (s *Session) Options() *Options {
+	return s.options
+}
+
+// SetTerm 记录客户端的终端类型, 由具体的协议在协商时调用
+func (s *Session) SetTerm(term string) {
+	s.termMu.Lock()
+	s.term = term
+	s.termMu.Unlock()
+}
+
+// Term 返回客户端的终端类型
+func (s *Session) Term() string {
+	s.termMu.Lock()
+	defer s.termMu.Unlock()
+	return s.term
+}
+
+// SetWindowSize 记录客户端的窗口大小, 由具体的协议在协商时调用
+func (s *Session) SetWindowSize(cols, rows int) {
+	s.termMu.Lock()
+	s.cols, s.rows = cols, rows
+	s.termMu.Unlock()
+}
+
+// WindowSize 返回客户端的窗口大小
+func (s *Session) WindowSize() (cols, rows int) {
+	s.termMu.Lock()
+	defer s.termMu.Unlock()
+	return s.cols, s.rows
+}
+
+// Line 返回当前正在执行的命令行
+func (s *Session) Line() []byte {
+	return s.line
+}
+
+func (s *Session) Write(p []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.w.Write(p)
+}
+
+func (s *Session) WriteString(str string) error {
+	_, err := s.Write([]byte(str))
+	return err
+}
+
+// WriteLine 输出一行，行中的 \n 会被转换成 \r\n，并保证以 \r\n 结束
+func (s *Session) WriteLine(line []byte) error {
+	_, err := s.Write(ToCRLF(line, true))
+	return err
+}
+
+// WritePages 分页输出 pages, 第 i 页之后显示 mores[i] 并等待按键, 按键后输出
+// moreAfter, 按 q 或 Ctrl+C 时中止输出
+func (s *Session) WritePages(pages []string, mores [][]byte, moreAfter []byte) error {
+	for idx, page := range pages {
+		if err := s.WriteLine([]byte(page)); err != nil {
+			return err
+		}
+		if idx == len(pages)-1 {
+			break
+		}
+		if idx < len(mores) && len(mores[idx]) > 0 && !s.dropMore() {
+			if _, err := s.Write(mores[idx]); err != nil {
+				return err
+			}
+		}
+
+		key, err := s.ReadKey()
+		if err != nil {
+			return err
+		}
+		if len(moreAfter) > 0 {
+			if _, err := s.Write(moreAfter); err != nil {
+				return err
+			}
+		}
+		if key == 'q' || key == 'Q' || key == 3 {
+			return s.WriteString("\r\n")
+		}
+	}
+	return nil
+}
+
+func (s *Session) readByte() (byte, error) {
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if s.skipLF {
+			s.skipLF = false
+			if b == '\n' || b == 0 {
+				continue
+			}
+		}
+		return b, nil
+	}
+}
+
+// ReadKey 读一个按键, 用于分页等场景
+func (s *Session) ReadKey() (byte, error) {
+	b, err := s.readByte()
+	if err != nil {
+		return 0, err
+	}
+	if b == '\r' {
+		s.skipLF = true
+	}
+	return b, nil
+}
+
+func (s *Session) readLine(echo, echoNewline bool) ([]byte, error) {
+	var line []byte
+	for {
+		b, err := s.readByte()
+		if err != nil {
+			return line, err
+		}
+
+		switch b {
+		case '\r', '\n':
+			if b == '\r' {
+				s.skipLF = true
+			}
+			if echo && s.faults != nil && s.faults.BackspaceRedraw && len(line) > 0 {
+				if err := s.redraw(line); err != nil {
+					return line, err
+				}
+			}
+			if echoNewline {
+				if err := s.WriteString("\r\n"); err != nil {
+					return line, err
+				}
+			}
+			return line, nil
+		case 8, 127: // 退格
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				if echo {
+					if _, err := s.Write([]byte{8, ' ', 8}); err != nil {
+						return line, err
+					}
+				}
+			}
+		case 3: // Ctrl+C 放弃当前行
+			if echoNewline {
+				if err := s.WriteString("^C\r\n"); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		default:
+			line = append(line, b)
+			if echo {
+				echoed := []byte{b}
+				if s.faults != nil && s.faults.DuplicateEcho {
+					echoed = append(echoed, b)
+				}
+				if _, err := s.Write(echoed); err != nil {
+					return line, err
+				}
+			}
+		}
+	}
+}
+
+// redraw 用退格清除已回显的命令行并重新显示, 一些设备在补全或刷新时会这样
+func (s *Session) redraw(line []byte) error {
+	bs := bytes.Repeat([]byte{8}, len(line))
+	bs = append(bs, line...)
+	_, err := s.Write(bs)
+	return err
+}
+
+// ReadLine 读一行命令, 输入的字符会被回显
+func (s *Session) ReadLine() ([]byte, error) {
+	return s.readLine(true, true)
+}
+
+// ReadPassword 读一个密码, 只回显最后的换行
+func (s *Session) ReadPassword() ([]byte, error) {
+	return s.readLine(false, true)
+}
+
+// ReadSilently 读一行，不回显任何字符
+func (s *Session) ReadSilently() ([]byte, error) {
+	return s.readLine(false, false)
+}
+
+// Loop 显示提示符并循环读取命令交给 fn 处理，fn 返回 ErrExit 时正常退出
+func (s *Session) Loop(prompt []byte, fn func(s *Session, line []byte) error) error {
+	for {
+		if _, err := s.Write(prompt); err != nil {
+			return err
+		}
+		line, err := s.ReadLine()
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		s.line = line
+		err = fn(s, line)
+		s.line = nil
+		if err != nil {
+			if err == ErrExit {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Login 用 telnet 或串口等方式连接时，模拟设备的登录过程
+func (s *Session) Login() error {
+	if s.options.noLogin() {
+		return nil
+	}
+
+	userQuest := s.options.UserQuest
+	if len(userQuest) == 0 {
+		userQuest = []byte("username:")
+	}
+	passwordQuest := s.options.PasswordQuest
+	if len(passwordQuest) == 0 {
+		passwordQuest = []byte("password:")
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Write(userQuest); err != nil {
+			return err
+		}
+		username, err := s.ReadSilently()
+		if err != nil {
+			return err
+		}
+		if _, err := s.Write(passwordQuest); err != nil {
+			return err
+		}
+		password, err := s.ReadSilently()
+		if err != nil {
+			return err
+		}
+
+		if s.options.Auth(string(username), string(password)) {
+			s.Username = string(username)
+			return nil
+		}
+		if err := s.WriteString("\r\nLogin invalid\r\n\r\n"); err != nil {
+			return err
+		}
+	}
+	return errors.New("login failed")
+}
+
+// Serve 显示欢迎信息, login 为 true 时先模拟登录，然后进入命令行
+func (s *Session) Serve(login bool) error {
+	if len(s.options.Welcome) > 0 {
+		if _, err := s.Write(s.options.Welcome); err != nil {
+			return err
+		}
+	}
+	if login {
+		if err := s.Login(); err != nil {
+			return err
+		}
+	}
+
+	handler := s.options.Handler
+	if handler == nil {
+		handler = Echo
+	}
+	prompt := s.options.Prompt
+	if len(prompt) == 0 {
+		prompt = []byte("#")
+	}
+	return handler(s, prompt)
+}
+
+// ToCRLF 将 \n 转换为 \r\n, endWithNewline 为 true 时保证结果以 \r\n 结束
+func ToCRLF(bs []byte, endWithNewline bool) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(bs) + 16)
+	for idx, b := range bs {
+		if b == '\n' && (idx == 0 || bs[idx-1] != '\r') {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte(b)
+	}
+	if endWithNewline && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
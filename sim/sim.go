@@ -0,0 +1,352 @@
+// Package sim 模拟一个网络设备的命令行, sshd 和 telnetd 等包在它之上实现具体的协议
+package sim
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Handler 是一个模式(视图)的处理函数, prompt 为这个模式的提示符
+//
+// Handler 返回 nil 表示用户退出了这个模式，会回到上一个模式
+type Handler func(s *Session, prompt []byte) error
+
+// Command 是一个命令的处理函数, line 为完整的命令行, args 为命令名之后的参数
+type Command func(s *Session, line, args []byte) error
+
+// Commands 为命令名到命令的映射，支持唯一前缀的缩写
+type Commands map[string]Command
+
+type userPassword struct {
+	username string
+	password string
+}
+
+// Options 是模拟设备的配置
+type Options struct {
+	Welcome       []byte
+	UserQuest     []byte
+	PasswordQuest []byte
+	Prompt        []byte
+	Handler       Handler
+
+	// InvalidCommand 为未知命令的错误提示, 其中的 {cmd} 会被替换为命令行,
+	// 为空时为 "% Invalid command: {cmd}"
+	InvalidCommand string
+
+	// Faults 不为 nil 时向会话中注入故障
+	Faults *Faults
+
+	// AllowTCPForwarding 为 true 时 ssh 服务接受 direct-tcpip 通道, 即可以作为跳板机
+	AllowTCPForwarding bool
+
+	users   []userPassword
+	keys    []userPublicKey
+	userCAs [][]byte
+}
+
+type userPublicKey struct {
+	username  string
+	publicKey []byte
+}
+
+func (o *Options) AddUserPassword(username, password string) {
+	o.users = append(o.users, userPassword{username: username, password: password})
+}
+
+func (o *Options) SetUserPassword(username, password string) {
+	o.users = []userPassword{{username: username, password: password}}
+}
+
+// AddUserPublicKey 添加一个可以登录的公钥(只用于 ssh), publicKey 为 ssh 的线路格式, 即 ssh.PublicKey.Marshal() 的结果
+func (o *Options) AddUserPublicKey(username string, publicKey []byte) {
+	o.keys = append(o.keys, userPublicKey{username: username, publicKey: publicKey})
+}
+
+// AddUserCA 添加一个签发用户证书的 CA(只用于 ssh), 它签发的证书中 principals 有用户名时可以登录,
+// publicKey 为 ssh 的线路格式
+func (o *Options) AddUserCA(publicKey []byte) {
+	o.userCAs = append(o.userCAs, publicKey)
+}
+
+func (o *Options) SetWelcome(welcome []byte) {
+	o.Welcome = welcome
+}
+
+func (o *Options) SetUserQuest(userQuest, passwordQuest []byte) {
+	o.UserQuest = userQuest
+	o.PasswordQuest = passwordQuest
+}
+
+func (o *Options) noLogin() bool {
+	if len(o.users) == 0 {
+		return len(o.keys) == 0 && len(o.userCAs) == 0
+	}
+	for _, u := range o.users {
+		if IsNone(u.username) && IsNone(u.password) {
+			return true
+		}
+	}
+	return false
+}
+
+// Auth 检查用户名和密码
+func (o *Options) Auth(username, password string) bool {
+	if o.noLogin() {
+		return true
+	}
+	for _, u := range o.users {
+		if u.username == username && checkPassword(u.password, []byte(password)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthPublicKey 检查用户名和公钥
+func (o *Options) AuthPublicKey(username string, publicKey []byte) bool {
+	for _, k := range o.keys {
+		if k.username == username && bytes.Equal(k.publicKey, publicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUserAuthority 检查是否为签发用户证书的 CA
+func (o *Options) IsUserAuthority(publicKey []byte) bool {
+	for _, ca := range o.userCAs {
+		if bytes.Equal(ca, publicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrompt 设置登录后的提示符和处理函数
+func (o *Options) WithPrompt(prompt []byte, handler Handler) {
+	o.Prompt = prompt
+	o.Handler = handler
+}
+
+// WithNoEnable 设置登录后的提示符和处理函数
+func (o *Options) WithNoEnable(prompt string, handler Handler) {
+	o.WithPrompt([]byte(prompt), handler)
+}
+
+// WithEnable 登录后为 prompt 提示符, 执行 enableCmd 并输入密码后进入 enablePrompt 提示符
+func (o *Options) WithEnable(prompt, enableCmd, passwordQuest, password, response, enablePrompt string, handler Handler) {
+	o.WithPrompt([]byte(prompt), WithEnable(enableCmd, passwordQuest, password, response, enablePrompt, handler))
+}
+
+// WithQuest 登录后先提问 quest, 回答 answer 后才显示提示符
+func (o *Options) WithQuest(quest, answer, prompt string, handler Handler) {
+	o.WithPrompt([]byte(prompt), WithQuest(quest, answer, handler))
+}
+
+func checkPassword(excepted string, actual []byte) bool {
+	if isEmpty(excepted) {
+		return len(actual) == 0
+	}
+	return excepted == string(actual)
+}
+
+func isExit(line []byte) bool {
+	return bytes.Equal(line, []byte("exit")) ||
+		bytes.Equal(line, []byte("quit"))
+}
+
+// Unknown 输出未知命令的错误
+func Unknown(s *Session, line []byte) error {
+	msg := s.options.InvalidCommand
+	if msg == "" {
+		msg = "% Invalid command: {cmd}"
+	}
+	return s.WriteLine([]byte(strings.Replace(msg, "{cmd}", string(line), -1)))
+}
+
+// WithQuest 先提问 quest, 回答 answer 后才进入 handler, 回答错误时重新提问
+func WithQuest(quest, answer string, handler Handler) Handler {
+	return func(s *Session, prompt []byte) error {
+		for {
+			if err := s.WriteString(quest); err != nil {
+				return err
+			}
+			line, err := s.ReadSilently()
+			if err != nil {
+				return err
+			}
+			if strings.EqualFold(strings.TrimSpace(string(line)), answer) {
+				break
+			}
+		}
+		return handler(s, prompt)
+	}
+}
+
+// WithEnable 执行 enableCmd 并输入正确的密码后以 enablePrompt 为提示符进入 handler
+//
+// password 为 <<none>> 时不需要输入密码, 为 <<empty>> 时需要输入空密码,
+// response 为进入 enable 模式后的输出, 为空时输出 "enable OK"
+func WithEnable(enableCmd, passwordQuest, password, response, enablePrompt string, handler Handler) Handler {
+	if response == "" {
+		response = "enable OK"
+	}
+	enter := EnterMode(passwordQuest, password, response, enablePrompt, handler)
+	return func(s *Session, prompt []byte) error {
+		return s.Loop(prompt, func(s *Session, line []byte) error {
+			if string(line) != enableCmd {
+				if isExit(line) {
+					return ErrExit
+				}
+				return Unknown(s, line)
+			}
+			return enter(s, line, nil)
+		})
+	}
+}
+
+// EnterMode 返回一个进入新模式的命令, 先校验密码(为 <<none>> 时不需要),
+// 然后输出 response 并以 prompt 为提示符进入 handler
+func EnterMode(passwordQuest, password, response, prompt string, handler Handler) Command {
+	return func(s *Session, line, args []byte) error {
+		if !IsNone(password) {
+			if err := s.WriteString(passwordQuest); err != nil {
+				return err
+			}
+			actual, err := s.ReadPassword()
+			if err != nil {
+				return err
+			}
+			if !checkPassword(password, actual) {
+				return s.WriteString("% Bad secrets\r\n")
+			}
+		}
+
+		if response != "" {
+			if err := s.WriteLine([]byte(response)); err != nil {
+				return err
+			}
+		}
+		return handler(s, []byte(prompt))
+	}
+}
+
+// WithSystemView 执行 cmd 后以 viewPrompt 为提示符进入 handler, 类似华三的 system-view
+func WithSystemView(cmd, response, viewPrompt string, handler Handler) Handler {
+	return func(s *Session, prompt []byte) error {
+		return s.Loop(prompt, func(s *Session, line []byte) error {
+			if string(line) != cmd {
+				if isExit(line) {
+					return ErrExit
+				}
+				return Unknown(s, line)
+			}
+			if response != "" {
+				if err := s.WriteLine([]byte(response)); err != nil {
+					return err
+				}
+			}
+			return handler(s, []byte(viewPrompt))
+		})
+	}
+}
+
+// OS 以 commands 为命令集合的一个模式
+func OS(commands Commands) Handler {
+	return func(s *Session, prompt []byte) error {
+		return s.Loop(prompt, func(s *Session, line []byte) error {
+			return commands.Run(s, line, line)
+		})
+	}
+}
+
+// Echo 是一个只支持 echo 命令的模式, "echo abc" 会输出 "print abc"
+var Echo = OS(Commands{
+	"echo": echo,
+})
+
+// echo 在所有的模式中都可用(除非被覆盖)，方便测试时确认当前的提示符
+func echo(s *Session, line, args []byte) error {
+	return s.WriteLine(append([]byte("print "), args...))
+}
+
+// WithCommands 将 commands 作为子命令, 如 "show" 下的 "running-config"
+func WithCommands(commands Commands) Command {
+	return func(s *Session, line, args []byte) error {
+		if len(args) == 0 {
+			return s.WriteString("% Incomplete command.\r\n")
+		}
+		return commands.Run(s, line, args)
+	}
+}
+
+// Lookup 查找命令, 名字不完整时按唯一的前缀匹配
+func (commands Commands) Lookup(name string) (Command, bool) {
+	if cmd, ok := commands[name]; ok {
+		return cmd, true
+	}
+
+	var found Command
+	count := 0
+	for key, cmd := range commands {
+		if strings.HasPrefix(key, name) {
+			found = cmd
+			count++
+		}
+	}
+	return found, count == 1
+}
+
+// Run 执行 args 中的第一个词对应的命令
+func (commands Commands) Run(s *Session, line, args []byte) error {
+	args = bytes.TrimSpace(args)
+	name := args
+	var remain []byte
+	if idx := bytes.IndexAny(args, " \t"); idx >= 0 {
+		name = args[:idx]
+		remain = bytes.TrimSpace(args[idx+1:])
+	}
+
+	cmd, ok := commands.Lookup(string(name))
+	if !ok {
+		if string(name) == "echo" {
+			return echo(s, line, remain)
+		}
+		if isExit(line) {
+			return ErrExit
+		}
+		return Unknown(s, line)
+	}
+	return cmd(s, line, remain)
+}
+
+// WithMore 分页输出 pages, 每页之后显示 more 并等待按键, 按键后输出 moreAfter
+// (一般是用来擦除 more 的控制字符), 按 q 或 Ctrl+C 时中止输出
+func WithMore(pages []string, more []byte, moreAfter []byte) Command {
+	mores := make([][]byte, len(pages))
+	for idx := range mores {
+		mores[idx] = more
+	}
+	return withMore(pages, mores, moreAfter)
+}
+
+// WithMoreArray 与 WithMore 相同，但每页之后的 more 可以不同，用来模拟某些
+// 设备偶尔不显示 more 的情况
+func WithMoreArray(pages []string, mores []string, moreAfter []byte) Command {
+	bs := make([][]byte, len(mores))
+	for idx := range mores {
+		bs[idx] = []byte(mores[idx])
+	}
+	return withMore(pages, bs, moreAfter)
+}
+
+func withMore(pages []string, mores [][]byte, moreAfter []byte) Command {
+	return func(s *Session, line, args []byte) error {
+		// 和部分设备一样，输出前会再显示一次命令行
+		if err := s.WriteLine(line); err != nil {
+			return err
+		}
+		return s.WritePages(pages, mores, moreAfter)
+	}
+}
@@ -1 +1,2 @@
+// Package sshd 是一个模拟网络设备的 ssh 服务, 用于测试
 package sshd
@@ -0,0 +1,272 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/mei-rune/shell/sim"
+	"golang.org/x/crypto/ssh"
+)
+
+type (
+	Options  = sim.Options
+	Session  = sim.Session
+	Handler  = sim.Handler
+	Command  = sim.Command
+	Commands = sim.Commands
+	Server   = sim.Server
+)
+
+var (
+	Echo           = sim.Echo
+	OS             = sim.OS
+	WithEnable     = sim.WithEnable
+	WithSystemView = sim.WithSystemView
+	WithQuest      = sim.WithQuest
+	WithCommands   = sim.WithCommands
+	WithMore       = sim.WithMore
+	WithMoreArray  = sim.WithMoreArray
+)
+
+var (
+	hostKeyOnce sync.Once
+	hostKey     ssh.Signer
+	hostKeyErr  error
+)
+
+func defaultHostKey() (ssh.Signer, error) {
+	hostKeyOnce.Do(func() {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			hostKeyErr = err
+			return
+		}
+		hostKey, hostKeyErr = ssh.NewSignerFromKey(priv)
+	})
+	return hostKey, hostKeyErr
+}
+
+// StartServer 启动一个模拟设备的 ssh 服务, addr 为 ":" 时随机选一个端口
+func StartServer(addr string, options *Options) (*Server, error) {
+	signer, err := defaultHostKey()
+	if err != nil {
+		return nil, err
+	}
+	return StartServerWithHostKey(addr, options, signer)
+}
+
+// StartServerWithHostKey 和 StartServer 相同, 但使用指定的主机密钥, signer 也可以是主机证书(ssh.NewCertSigner)
+func StartServerWithHostKey(addr string, options *Options, signer ssh.Signer) (*Server, error) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if options.Auth(c.User(), string(password)) {
+				return nil, nil
+			}
+			return nil, errPasswordInvalid
+		},
+	}
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return options.IsUserAuthority(auth.Marshal())
+		},
+		UserKeyFallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if options.AuthPublicKey(c.User(), key.Marshal()) {
+				return nil, nil
+			}
+			return nil, errPublicKeyInvalid
+		},
+	}
+	config.PublicKeyCallback = checker.Authenticate
+	config.AddHostKey(signer)
+
+	return sim.Listen(addr, func(conn net.Conn) {
+		serveConn(conn, config, options)
+	})
+}
+
+type authError string
+
+func (e authError) Error() string {
+	return string(e)
+}
+
+const (
+	errPasswordInvalid  = authError("password is invalid")
+	errPublicKeyInvalid = authError("public key is invalid")
+)
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig, options *Options) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" && options.AllowTCPForwarding {
+			go serveDirectTCPIP(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go serveSession(sconn, channel, requests, options)
+	}
+}
+
+// serveDirectTCPIP 连接通道中指定的地址, 并在两者之间转发数据
+func serveDirectTCPIP(newChannel ssh.NewChannel) {
+	var target struct {
+		Host           string
+		Port           uint32
+		OriginatorIP   string
+		OriginatorPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip payload")
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.FormatUint(uint64(target.Port), 10)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+		channel.Close()
+	}()
+	io.Copy(conn, channel)
+	conn.Close()
+}
+
+func serveSession(sconn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request, options *Options) {
+	input := newSessionInput(channel)
+	session := sim.NewSession(input, channel, options)
+	session.Username = sconn.User()
+	started := false
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			var pty struct {
+				Term                         string
+				Columns, Rows, Width, Height uint32
+				Modes                        string
+			}
+			if err := ssh.Unmarshal(req.Payload, &pty); err == nil {
+				session.SetTerm(pty.Term)
+				session.SetWindowSize(int(pty.Columns), int(pty.Rows))
+			}
+			req.Reply(true, nil)
+		case "window-change":
+			var win struct{ Columns, Rows, Width, Height uint32 }
+			if err := ssh.Unmarshal(req.Payload, &win); err == nil {
+				session.SetWindowSize(int(win.Columns), int(win.Rows))
+			}
+			req.Reply(true, nil)
+		case "env":
+			req.Reply(true, nil)
+		case "break":
+			// 和 telnetd 中的 IAC BRK 一样当作 Ctrl+C
+			input.inject(3)
+			req.Reply(true, nil)
+		case "signal":
+			var sig struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &sig); err == nil && sig.Name == string(ssh.SIGINT) {
+				input.inject(3)
+			}
+			req.Reply(false, nil)
+		case "shell":
+			if started {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+
+			go func() {
+				err := session.Serve(false)
+
+				var status *sim.ExitStatus
+				if errors.As(err, &status) && status.Signal != "" {
+					channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+						Signal     string
+						CoreDumped bool
+						Error      string
+						Lang       string
+					}{Signal: status.Signal, Error: status.Error()}))
+				} else if status != nil {
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status.Code)}))
+				} else {
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				}
+				channel.Close()
+			}()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// sessionInput 将 channel 中的数据和 break, signal 请求转换成的字符合并成 Session 的输入
+type sessionInput struct {
+	mu sync.Mutex
+	pr *io.PipeReader
+	pw *io.PipeWriter
+}
+
+func newSessionInput(channel ssh.Channel) *sessionInput {
+	pr, pw := io.Pipe()
+	input := &sessionInput{pr: pr, pw: pw}
+	go func() {
+		var buf [1024]byte
+		for {
+			n, err := channel.Read(buf[:])
+			if n > 0 {
+				input.mu.Lock()
+				_, e := pw.Write(buf[:n])
+				input.mu.Unlock()
+				if e != nil {
+					return
+				}
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return input
+}
+
+func (input *sessionInput) Read(p []byte) (int, error) {
+	return input.pr.Read(p)
+}
+
+func (input *sessionInput) inject(b byte) {
+	go func() {
+		input.mu.Lock()
+		defer input.mu.Unlock()
+		input.pw.Write([]byte{b})
+	}()
+}
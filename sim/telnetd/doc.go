@@ -1 +1,2 @@
+// Package telnetd 是一个模拟网络设备的 telnet 服务, 用于测试
 package telnetd
@@ -0,0 +1,287 @@
+package telnetd
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/mei-rune/shell/sim"
+)
+
+type (
+	Options  = sim.Options
+	Session  = sim.Session
+	Handler  = sim.Handler
+	Command  = sim.Command
+	Commands = sim.Commands
+	Server   = sim.Server
+)
+
+var (
+	Echo           = sim.Echo
+	OS             = sim.OS
+	WithEnable     = sim.WithEnable
+	WithSystemView = sim.WithSystemView
+	WithQuest      = sim.WithQuest
+	WithCommands   = sim.WithCommands
+	WithMore       = sim.WithMore
+	WithMoreArray  = sim.WithMoreArray
+)
+
+const (
+	cmdSE   = 240
+	cmdNOP  = 241
+	cmdBRK  = 243
+	cmdIP   = 244
+	cmdAYT  = 246
+	cmdEC   = 247
+	cmdEL   = 248
+	cmdGA   = 249
+	cmdSB   = 250
+	cmdWill = 251
+	cmdWont = 252
+	cmdDo   = 253
+	cmdDont = 254
+	cmdIAC  = 255
+
+	optEcho            = 1
+	optSuppressGoAhead = 3
+	optTermType        = 24
+	optWndSize         = 31
+
+	ttypeIs   = 0
+	ttypeSend = 1
+)
+
+// StartServer 启动一个模拟设备的 telnet 服务, addr 为 ":" 时随机选一个端口
+func StartServer(addr string, options *Options) (*Server, error) {
+	return sim.Listen(addr, func(conn net.Conn) {
+		serveConn(conn, options)
+	})
+}
+
+func serveConn(conn net.Conn, options *Options) {
+	c := &telnetConn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		local:   map[byte]bool{},
+		remote:  map[byte]bool{},
+		refused: map[byte]bool{},
+	}
+
+	pr, pw := io.Pipe()
+	c.session = sim.NewSession(pr, c, options)
+
+	// 和大多数设备一样，由服务端回显并且不用 GA, 同时询问终端类型和窗口大小
+	if err := c.negotiate(); err != nil {
+		return
+	}
+
+	go func() {
+		pw.CloseWithError(c.readLoop(pw))
+	}()
+
+	c.session.Serve(true)
+}
+
+// telnetConn 负责 telnet 协议的协商, 并将去掉协议控制字符后的数据交给 Session
+type telnetConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	wmu     sync.Mutex
+	session *sim.Session
+
+	// local 为服务端已启用的选项, remote 为客户端已启用(或已向客户端请求)的选项,
+	// refused 为已拒绝过的选项，避免反复协商
+	local   map[byte]bool
+	remote  map[byte]bool
+	refused map[byte]bool
+}
+
+func (c *telnetConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.conn.Write(p)
+}
+
+func (c *telnetConn) command(cmd, opt byte) error {
+	_, err := c.Write([]byte{cmdIAC, cmd, opt})
+	return err
+}
+
+func (c *telnetConn) sub(opt byte, data ...byte) error {
+	bs := make([]byte, 0, len(data)+6)
+	bs = append(bs, cmdIAC, cmdSB, opt)
+	for _, b := range data {
+		if b == cmdIAC {
+			bs = append(bs, cmdIAC)
+		}
+		bs = append(bs, b)
+	}
+	bs = append(bs, cmdIAC, cmdSE)
+	_, err := c.Write(bs)
+	return err
+}
+
+func (c *telnetConn) negotiate() error {
+	for _, opt := range []byte{optEcho, optSuppressGoAhead} {
+		c.local[opt] = true
+		if err := c.command(cmdWill, opt); err != nil {
+			return err
+		}
+	}
+	for _, opt := range []byte{optTermType, optWndSize} {
+		c.remote[opt] = true
+		if err := c.command(cmdDo, opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *telnetConn) readLoop(w io.Writer) error {
+	var buf []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b != cmdIAC {
+			buf = append(buf, b)
+		} else if b, err = c.readCommand(); err != nil {
+			return err
+		} else if b != 0 {
+			buf = append(buf, b)
+		}
+
+		// 一次读到的数据尽量一起交给 Session
+		if len(buf) > 0 && c.r.Buffered() == 0 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+}
+
+// readCommand 处理 IAC 之后的命令, 返回值不为 0 时表示它对应一个数据字节
+func (c *telnetConn) readCommand() (byte, error) {
+	cmd, err := c.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch cmd {
+	case cmdIAC:
+		return cmdIAC, nil
+	case cmdIP, cmdBRK:
+		return 3, nil // 当作 Ctrl+C
+	case cmdEC:
+		return 8, nil
+	case cmdAYT:
+		_, err = c.Write([]byte("\r\n[Yes]\r\n"))
+		return 0, err
+	case cmdSB:
+		return 0, c.readSub()
+	case cmdDo, cmdDont, cmdWill, cmdWont:
+		opt, err := c.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return 0, c.option(cmd, opt)
+	default:
+		// NOP, GA, DM, AO, EL 等都忽略
+		return 0, nil
+	}
+}
+
+func (c *telnetConn) option(cmd, opt byte) error {
+	switch cmd {
+	case cmdDo:
+		switch opt {
+		case optEcho, optSuppressGoAhead:
+			if c.local[opt] {
+				return nil
+			}
+			c.local[opt] = true
+			return c.command(cmdWill, opt)
+		}
+		if c.refused[opt] {
+			return nil
+		}
+		c.refused[opt] = true
+		return c.command(cmdWont, opt)
+	case cmdDont:
+		if !c.local[opt] {
+			return nil
+		}
+		c.local[opt] = false
+		return c.command(cmdWont, opt)
+	case cmdWill:
+		switch opt {
+		case optTermType, optWndSize:
+			if !c.remote[opt] {
+				c.remote[opt] = true
+				if err := c.command(cmdDo, opt); err != nil {
+					return err
+				}
+			}
+			if opt == optTermType {
+				return c.sub(optTermType, ttypeSend)
+			}
+			return nil
+		}
+		if c.refused[opt] {
+			return nil
+		}
+		c.refused[opt] = true
+		return c.command(cmdDont, opt)
+	case cmdWont:
+		if !c.remote[opt] {
+			return nil
+		}
+		c.remote[opt] = false
+		return c.command(cmdDont, opt)
+	}
+	return nil
+}
+
+func (c *telnetConn) readSub() error {
+	opt, err := c.r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == cmdIAC {
+			if b, err = c.r.ReadByte(); err != nil {
+				return err
+			}
+			if b == cmdSE {
+				break
+			}
+		}
+		data = append(data, b)
+	}
+
+	switch opt {
+	case optTermType:
+		if len(data) > 0 && data[0] == ttypeIs {
+			c.session.SetTerm(string(data[1:]))
+		}
+	case optWndSize:
+		if len(data) >= 4 {
+			cols := int(data[0])<<8 | int(data[1])
+			rows := int(data[2])<<8 | int(data[3])
+			c.session.SetWindowSize(cols, rows)
+		}
+	}
+	return nil
+}
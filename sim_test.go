@@ -0,0 +1,79 @@
+package shell
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mei-rune/shell/sim"
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
+	"golang.org/x/crypto/ssh"
+)
+
+// simConfig 为测试用的模拟设备的参数, 零值为用户 abc/123, 提示符 ABC>, 回显收到的命令
+type simConfig struct {
+	User     string
+	Password string
+	Prompt   string
+	// Handler 为 nil 时为 sim.Echo
+	Handler sim.Handler
+
+	// HostKey 为 ssh 的主机密钥, nil 时使用 sshd 缺省的密钥
+	HostKey ssh.Signer
+	// PublicKeys 为 ssh 用户可以使用的公钥
+	PublicKeys []ssh.PublicKey
+	// AllowTCPForwarding 为 true 时 ssh 可以作为跳板机
+	AllowTCPForwarding bool
+
+	Faults *sim.Faults
+}
+
+func (cfg simConfig) options() *sim.Options {
+	user, password, prompt, handler := cfg.User, cfg.Password, cfg.Prompt, cfg.Handler
+	if user == "" {
+		user, password = "abc", "123"
+	}
+	if prompt == "" {
+		prompt = "ABC>"
+	}
+	if handler == nil {
+		handler = sim.Echo
+	}
+
+	options := &sim.Options{
+		Faults:             cfg.Faults,
+		AllowTCPForwarding: cfg.AllowTCPForwarding,
+	}
+	options.AddUserPassword(user, password)
+	for _, key := range cfg.PublicKeys {
+		options.AddUserPublicKey(user, key.Marshal())
+	}
+	options.WithNoEnable(prompt, handler)
+	return options
+}
+
+// startSSHSim 启动一个 ssh 模拟设备, 返回它和它的地址
+func startSSHSim(t *testing.T, cfg simConfig) (*sshd.Server, string) {
+	t.Helper()
+	var listener *sshd.Server
+	var err error
+	if cfg.HostKey != nil {
+		listener, err = sshd.StartServerWithHostKey(":", cfg.options(), cfg.HostKey)
+	} else {
+		listener, err = sshd.StartServer(":", cfg.options())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener, net.JoinHostPort("127.0.0.1", listener.Port())
+}
+
+// startTelnetSim 启动一个 telnet 模拟设备, 返回它和它的地址
+func startTelnetSim(t *testing.T, cfg simConfig) (*telnetd.Server, string) {
+	t.Helper()
+	listener, err := telnetd.StartServer(":", cfg.options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener, net.JoinHostPort("127.0.0.1", listener.Port())
+}
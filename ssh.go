@@ -1,9 +1,13 @@
 package shell
 
 import (
+	"context"
 	"io"
+	"net"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/runner-mei/errors"
 	"golang.org/x/crypto/ssh"
@@ -114,8 +118,75 @@ func init() {
 	}
 }
 
+// SSHOptions 为 ssh 连接的可选参数, 零值表示使用缺省值
+type SSHOptions struct {
+	// Columns 和 Rows 为终端窗口的初始大小, 为 0 时使用 DefaultColumns 和 DefaultRows
+	Columns int
+	Rows    int
+	// Term 为终端类型, 为空时使用 xterm
+	Term string
+	// Modes 为终端的模式, 会覆盖缺省的模式(关闭回显, 速率 115200)
+	Modes ssh.TerminalModes
+
+	// KeepaliveInterval 为发送 keepalive@openssh.com 请求的间隔, 也是每个请求等待回应的超时, 为 0 时不发送
+	KeepaliveInterval time.Duration
+	// KeepaliveMaxMissed 为最多连续没有回应的次数, 为 0 时使用 DefaultKeepaliveMaxMissed,
+	// 超过后关闭连接, Expect 返回 *KeepaliveError
+	KeepaliveMaxMissed int
+
+	// Ciphers 和 KeyExchanges 为允许的算法, 为空时使用 SupportedCiphers 和 SupportedKeyExchanges
+	Ciphers      []string
+	KeyExchanges []string
+	// Timeout 为建立 tcp 连接和握手的超时, 0 表示不限制
+	Timeout time.Duration
+	// HostKeyCallback 为主机密钥的验证方法, 可以用 HostKeyPolicy.Callback 创建.
+	// 为 nil 时不验证主机密钥(以前的行为), 不能防止中间人攻击
+	HostKeyCallback ssh.HostKeyCallback
+
+	// PrivateKeys 为更多的候选私钥(PEM 格式), 在 privateKey 参数之后按顺序尝试
+	PrivateKeys []string
+	// Passphrase 为加密的私钥的密码, 它和登录密码是分开的, 为空时加密的私钥无法使用
+	Passphrase string
+	// PasswordAsPassphrase 为 true 且 Passphrase 为空时用登录密码解密私钥, 兼容以前的行为
+	PasswordAsPassphrase bool
+	// UseAgent 为 true 时还尝试 ssh-agent 中的密钥, 它们在私钥之后
+	UseAgent bool
+	// AgentSocket 为 ssh-agent 的 socket, 为空时使用环境变量 SSH_AUTH_SOCK
+	AgentSocket string
+	// Certificates 为 OpenSSH 的用户证书(和 id_ed25519-cert.pub 文件的格式相同), 每个证书和公钥相同的
+	// 私钥(或 ssh-agent 中的密钥)一起使用, 先尝试证书再尝试密钥本身
+	Certificates []string
+
+	// JumpHosts 为按顺序经过的跳板机, 和 ssh -J 相同
+	JumpHosts []JumpHost
+	// Dialer 用于建立 tcp 连接(有跳板机时连接第一个跳板机), 可以用 ParseProxy 创建代理,
+	// 为 nil 时使用 ProxyFromEnvironment()
+	Dialer ContextDialer
+}
+
 func ConnectSSH(host, user, password, privateKey string, sWriter, cWriter io.Writer) (Conn, error) {
-	conn, err := DialSSH(host, user, password, privateKey)
+	return ConnectSSHWithOptions(host, user, password, privateKey, nil, sWriter, cWriter)
+}
+
+// ConnectSSHWithOptions 和 ConnectSSH 相同, 但可以用 opts 指定终端窗口大小等, opts 可以为 nil
+func ConnectSSHWithOptions(host, user, password, privateKey string, opts *SSHOptions, sWriter, cWriter io.Writer) (Conn, error) {
+	return ConnectSSHContext(context.Background(), host, user, password, privateKey, opts, sWriter, cWriter)
+}
+
+// ConnectSSHContext 和 ConnectSSHWithOptions 相同, ctx 取消或超时时中断连接和握手
+func ConnectSSHContext(ctx context.Context, host, user, password, privateKey string, opts *SSHOptions, sWriter, cWriter io.Writer) (Conn, error) {
+	if opts == nil {
+		opts = &SSHOptions{}
+	}
+	cols, rows := opts.Columns, opts.Rows
+	if cols <= 0 {
+		cols = DefaultColumns
+	}
+	if rows <= 0 {
+		rows = DefaultRows
+	}
+
+	conn, meta, err := dialSSH(ctx, host, user, password, privateKey, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -133,8 +204,15 @@ func ConnectSSH(host, user, password, privateKey string, sWriter, cWriter io.Wri
 		ssh.TTY_OP_ISPEED: 115200, // input speed = 14.4kbaud
 		ssh.TTY_OP_OSPEED: 115200, // output speed = 14.4kbaud
 	}
+	for k, v := range opts.Modes {
+		modes[k] = v
+	}
+	term := opts.Term
+	if term == "" {
+		term = "xterm"
+	}
 	// Request pseudo terminal
-	if err := session.RequestPty("xterm", 800, 1600, modes); err != nil {
+	if err := session.RequestPty(term, rows, cols, modes); err != nil {
 		conn.Close()
 		session.Close()
 		return nil, errors.Wrap(err, "request for pseudo terminal failed")
@@ -154,6 +232,14 @@ func ConnectSSH(host, user, password, privateKey string, sWriter, cWriter io.Wri
 	}
 	session.Stderr = session.Stdout
 
+	exit := newExitRecorder()
+	if opts.KeepaliveInterval > 0 {
+		StartSSHKeepalive(conn, opts.KeepaliveInterval, opts.KeepaliveMaxMissed, func(err error) {
+			exit.setError(err)
+			p.CloseWithError(err)
+		})
+	}
+
 	// Start remote shell
 	if err := session.Shell(); err != nil {
 		conn.Close()
@@ -163,9 +249,12 @@ func ConnectSSH(host, user, password, privateKey string, sWriter, cWriter io.Wri
 
 	go func() {
 		err := session.Wait()
-		if err != nil {
-			p.CloseWithError(err)
+		if status := sshExitStatus(err); status != nil {
+			exit.set(status)
+		} else {
+			exit.setError(err)
 		}
+		p.CloseWithError(err)
 	}()
 
 	if cWriter != nil {
@@ -178,7 +267,21 @@ func ConnectSSH(host, user, password, privateKey string, sWriter, cWriter io.Wri
 		w:               cWriter,
 		r:               p,
 		readByte:        p,
+		readByteContext: p,
+		readContext:     p,
 		drainto:         p,
+		signaler:        sshSession{session},
+		resizer:         sshSession{session},
+		exit:            exit,
+		metadata: func() *Metadata {
+			copyed := *meta
+			return &Metadata{
+				Transport:  "ssh",
+				RemoteAddr: addrString(conn.RemoteAddr()),
+				LocalAddr:  addrString(conn.LocalAddr()),
+				SSH:        &copyed,
+			}
+		},
 		setReadDeadline: p,
 		// setWriteDeadline: p,
 	}, nil
@@ -186,21 +289,124 @@ func ConnectSSH(host, user, password, privateKey string, sWriter, cWriter io.Wri
 
 // DailSSH 连接到 ssh 服务
 func DialSSH(host, user, password, privateKey string) (*ssh.Client, error) {
+	conn, _, err := dialSSH(context.Background(), host, user, password, privateKey, nil)
+	return conn, err
+}
+
+// dialSSH 和 DialSSH 相同, 同时返回协商的结果, opts 可以为 nil.
+// opts.Timeout 和 ctx 的截止时间都有效
+func dialSSH(ctx context.Context, host, user, password, privateKey string, opts *SSHOptions) (*ssh.Client, *SSHMetadata, error) {
+	if opts == nil {
+		opts = &SSHOptions{}
+	}
+	dialer, err := dialerOrDefault(opts.Dialer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	dial, closeJumps, err := dialJumpHosts(ctx, dialer, opts.JumpHosts, opts.Timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, meta, err := dialSSHContext(ctx, dial, host, user, password, privateKey, opts)
+	if err != nil {
+		closeJumps()
+		return nil, nil, err
+	}
+	if len(opts.JumpHosts) > 0 {
+		go func() {
+			client.Wait()
+			closeJumps()
+		}()
+	}
+	return client, meta, nil
+}
+
+// dialSSHContext 和 dialSSHVia 相同, ctx 取消或超时时关闭正在握手的连接并返回 ctx.Err()
+func dialSSHContext(ctx context.Context, dial dialFunc, host, user, password, privateKey string, opts *SSHOptions) (*ssh.Client, *SSHMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		conn     net.Conn
+		finished bool
+	)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			if conn != nil && !finished {
+				conn.Close()
+			}
+			mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	client, meta, err := dialSSHVia(func(network, addr string) (net.Conn, error) {
+		c, err := dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		conn = c
+		mu.Unlock()
+		return c, nil
+	}, host, user, password, privateKey, opts)
+
+	mu.Lock()
+	finished = true
+	mu.Unlock()
+	close(stop)
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if err == nil {
+			client.Close()
+		}
+		return nil, nil, ctxErr
+	}
+	return client, meta, err
+}
+
+// dialSSHVia 用 dial 建立连接后进行 ssh 握手
+func dialSSHVia(dial dialFunc, host, user, password, privateKey string, opts *SSHOptions) (*ssh.Client, *SSHMetadata, error) {
+	ciphers := opts.Ciphers
+	if len(ciphers) == 0 {
+		ciphers = SupportedCiphers
+	}
+	keyExchanges := opts.KeyExchanges
+	if len(keyExchanges) == 0 {
+		keyExchanges = SupportedKeyExchanges
+	}
+
+	hostKeyCallback := opts.HostKeyCallback
+	if hostKeyCallback == nil {
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	}
+
+	var auth authRecorder
 	var buffer strings.Builder
 	interactiveCount := 0
 	config := &ssh.ClientConfig{
-		Config:          ssh.Config{Ciphers: SupportedCiphers, KeyExchanges: SupportedKeyExchanges},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Config:          ssh.Config{Ciphers: ciphers, KeyExchanges: keyExchanges},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         opts.Timeout,
 		User:            user,
 		Auth: []ssh.AuthMethod{
-			// ClientAuthPassword wraps a ClientPassword implementation
-			// in a type that implements ClientAuth.
-			ssh.Password(password),
 			ssh.PasswordCallback(func() (string, error) {
+				auth.attempt("password")
 				return password, nil
 			}),
 
 			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) (answers []string, err error) {
+				auth.attempt("keyboard-interactive")
 				interactiveCount++
 				if interactiveCount > 20 {
 					return nil, errors.New("interactive count is too much")
@@ -224,36 +430,87 @@ func DialSSH(host, user, password, privateKey string) (*ssh.Client, error) {
 		},
 	}
 
+	keys := opts.PrivateKeys
 	if privateKey != "" {
-		if password == "" {
-			signer, err := ssh.ParsePrivateKey([]byte(privateKey))
-			if err != nil {
-				return nil, errors.Wrap(err, "unable to parse private key")
-			}
-
-			config.Auth = append(config.Auth, ssh.PublicKeys(signer))
-		} else {
-			signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(password))
-			if err != nil {
-				return nil, errors.Wrap(err, "unable to parse private key")
-			}
-			config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+		keys = append([]string{privateKey}, keys...)
+	}
+	passphrase := opts.Passphrase
+	if passphrase == "" && opts.PasswordAsPassphrase {
+		passphrase = password
+	}
+	authKeys, err := privateKeySigners(keys, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.UseAgent {
+		agentConn, agentKeys, err := agentSigners(opts.AgentSocket)
+		if err != nil {
+			return nil, nil, err
 		}
+		defer agentConn.Close()
+		authKeys = append(authKeys, agentKeys...)
+	}
+	authKeys, err = withCertificates(authKeys, opts.Certificates)
+	if err != nil {
+		return nil, nil, err
+	}
+	signers := make([]ssh.Signer, 0, len(authKeys))
+	for _, key := range authKeys {
+		signers = append(signers, recordSigner(key.signer, key.source, &auth))
+	}
+	if len(signers) > 0 {
+		// 同一种认证方法 ssh 包只会尝试一次, 所以所有的密钥要放在一起
+		config.Auth = append(config.Auth, ssh.PublicKeys(signers...))
+	}
+
+	// 握手失败时 ssh 包只保留了错误的文本, 这里记下原始的错误, 以便返回 *HostKeyError
+	var hostKeyErr error
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKeyErr = hostKeyCallback(hostname, remote, key)
+		return hostKeyErr
 	}
 
-	conn, err := ssh.Dial("tcp", host, config)
+	meta := &SSHMetadata{User: user}
+	config.HostKeyCallback = recordHostKey(meta, config.HostKeyCallback)
+	conn, err := sshDial(dial, "tcp", host, config, meta)
 	if err != nil {
+		if hostKeyErr != nil {
+			return nil, nil, hostKeyErr
+		}
 		if buffer.Len() != 0 {
-			if password == "" && privateKey == "" {
-				return nil, errors.WrapWithSuffix(err, "可能是因为密码为空?\r\n"+buffer.String())
+			if password == "" && len(signers) == 0 {
+				return nil, nil, errors.WrapWithSuffix(err, "可能是因为密码为空?\r\n"+buffer.String())
 			}
-			return nil, errors.WrapWithSuffix(err, buffer.String())
+			return nil, nil, errors.WrapWithSuffix(err, buffer.String())
 		}
 
-		if password == "" && privateKey == "" {
-			return nil, errors.WrapWithSuffix(err, "可能是因为密码为空?")
+		if password == "" && len(signers) == 0 {
+			return nil, nil, errors.WrapWithSuffix(err, "可能是因为密码为空?")
 		}
+		return nil, nil, err
+	}
+	auth.apply(meta)
+	return conn, meta, nil
+}
+
+// sshDial 和 ssh.Dial 相同, 但是用 dial 建立连接, 并且会在 meta 中记录协商出的算法等
+func sshDial(dial dialFunc, network, addr string, config *ssh.ClientConfig, meta *SSHMetadata) (*ssh.Client, error) {
+	conn, err := dial(network, addr)
+	if err != nil {
 		return nil, err
 	}
-	return conn, nil
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	sniffer := &kexSniffer{Conn: conn}
+	c, chans, reqs, err := ssh.NewClientConn(sniffer, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Time{})
+	}
+	meta.ServerVersion = string(c.ServerVersion())
+	sniffer.fill(meta)
+	return ssh.NewClient(c, chans, reqs), nil
 }
@@ -7,8 +7,7 @@ import (
 	"testing"
 	"time"
 
-	// "github.com/mei-rune/shell/sim/sshd"
-	"tech.hengwei.com.cn/go/private/sim/sshd"
+	"github.com/mei-rune/shell/sim/sshd"
 )
 
 var answerNo = Match("abc? [Y/N]:", SayNoCRLF)
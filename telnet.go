@@ -3,11 +3,16 @@ package shell
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
+
+	"github.com/runner-mei/errors"
 )
 
 const (
@@ -94,16 +99,36 @@ const (
 type Telnet struct {
 	nconn net.Conn
 
-	columns, rows byte
+	// columns 和 rows 为 NAWS 中的窗口大小, naws 表示对端已经同意了 NAWS,
+	// termTypes 为 TTYPE 中轮流回答的终端类型, termTypeIdx 为下一次回答的位置, termType 为最后一次回答的
+	termMu        sync.Mutex
+	columns, rows int
+	naws          bool
+	termTypes     []string
+	termTypeIdx   int
+	termType      string
 	w             io.Writer
 	r             *bufio.Reader
 
+	// localOpts 为本地已经启用的选项, remoteOpts 为对端已经启用的选项, 用于 Metadata
+	localOpts  [256]bool
+	remoteOpts [256]bool
+
 	unixWriteMode bool
 
 	cliSuppressGoAhead bool
 	cliEcho            bool
 
 	errc chan error
+	exit *exitRecorder
+
+	// keepalive 的设置, 见 SetKeepalive. lastRead 为最后一次收到数据的时间(UnixNano),
+	// activity 在收到数据时被通知, 用于 AYT
+	keepaliveInterval  time.Duration
+	keepaliveMaxMissed int
+	keepaliveAYT       bool
+	lastRead           int64
+	activity           chan struct{}
 }
 
 func NewTelnet(conn net.Conn) *Telnet {
@@ -125,36 +150,63 @@ func NewTelnet2(conn net.Conn, w io.Writer, r io.Reader) *Telnet {
 
 func TelnetWrap(c *Telnet, tees, teec io.Writer) *ConnWrapper {
 	c.errc = make(chan error, 1)
+	c.activity = make(chan struct{}, 1)
+	c.exit = newExitRecorder()
 	p := MakePipe(0)
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 
-		var a [1]byte
+		var buf [256]byte
 		// 请注意这里不能用 io.Copy()
 		for {
+			// 先阻塞读一个字节, 然后将 bufio 中已缓存的数据解码后一次写入 pipe
 			b, err := c.ReadByte()
-			if err != nil {
-				c.errc <- err
-				close(c.errc)
+			n := 0
+			if err == nil {
+				buf[0] = b
+				n, err = c.readBuffered(buf[1:])
+				n++
+			}
 
-				p.CloseWithError(err)
-				break
+			if n > 0 {
+				atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
+				select {
+				case c.activity <- struct{}{}:
+				default:
+				}
+
+				if _, e := p.Write(buf[:n]); e != nil {
+					err = e
+				} else if tees != nil {
+					tees.Write(buf[:n])
+				}
 			}
 
-			if err = p.WriteByte(b); err != nil {
+			if err != nil {
+				c.exit.setError(err)
 				c.errc <- err
 				close(c.errc)
 
 				p.CloseWithError(err)
 				break
 			}
-
-			if tees != nil {
-				a[0] = b
-				tees.Write(a[:])
-			}
 		}
 	}()
 
+	if c.keepaliveInterval > 0 {
+		atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
+		go keepalive(c.keepaliveInterval, c.keepaliveMaxMissed, done, func() time.Duration {
+			return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastRead)))
+		}, func() error {
+			return c.ping(done)
+		}, func(err error) {
+			c.exit.setError(err)
+			p.CloseWithError(err)
+			c.nconn.Close()
+		})
+	}
+
 	var w io.Writer = c
 	if teec != nil {
 		w = MultWriters(w, teec)
@@ -165,22 +217,36 @@ func TelnetWrap(c *Telnet, tees, teec io.Writer) *ConnWrapper {
 		w:               w,
 		r:               p,
 		readByte:        p,
+		readByteContext: p,
+		readContext:     p,
 		drainto:         p,
+		signaler:        c,
+		resizer:         c,
+		exit:            c.exit,
+		metadata:        c.Metadata,
 		setReadDeadline: p,
 		// setWriteDeadline: p,
 	}
 }
 
+// DialTelnet 连接 telnet 服务, 设置了环境变量 ALL_PROXY 时通过代理连接, 见 ProxyFromEnvironment
 func DialTelnet(network, addr string) (*Telnet, error) {
-	conn, err := net.Dial(network, addr)
-	if err != nil {
-		return nil, err
-	}
-	return NewTelnet(conn), nil
+	return DialTelnetTimeout(network, addr, 0)
 }
 
+// DialTelnetTimeout 和 DialTelnet 相同, timeout 为建立连接的超时, 0 表示不限制
 func DialTelnetTimeout(network, addr string, timeout time.Duration) (*Telnet, error) {
-	conn, err := net.DialTimeout(network, addr, timeout)
+	dialer, err := ProxyFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := dialer.DialContext(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -188,6 +254,9 @@ func DialTelnetTimeout(network, addr string, timeout time.Duration) (*Telnet, er
 }
 
 func (c *Telnet) Close() error {
+	if c.exit != nil {
+		c.exit.markClosing()
+	}
 	err := c.nconn.Close()
 
 	if c.errc != nil {
@@ -207,24 +276,36 @@ func (c *Telnet) SetUnixWriteMode(uwm bool) {
 }
 
 func (c *Telnet) do(option byte) error {
+	c.termMu.Lock()
+	c.remoteOpts[option] = true
+	c.termMu.Unlock()
 	//log.Println("do:", option)
 	_, err := c.w.Write([]byte{cmdIAC, cmdDo, option})
 	return err
 }
 
 func (c *Telnet) dont(option byte) error {
+	c.termMu.Lock()
+	c.remoteOpts[option] = false
+	c.termMu.Unlock()
 	//log.Println("dont:", option)
 	_, err := c.w.Write([]byte{cmdIAC, cmdDont, option})
 	return err
 }
 
 func (c *Telnet) will(option byte) error {
+	c.termMu.Lock()
+	c.localOpts[option] = true
+	c.termMu.Unlock()
 	//log.Println("will:", option)
 	_, err := c.w.Write([]byte{cmdIAC, cmdWill, option})
 	return err
 }
 
 func (c *Telnet) wont(option byte) error {
+	c.termMu.Lock()
+	c.localOpts[option] = false
+	c.termMu.Unlock()
 	//log.Println("wont:", option)
 	_, err := c.w.Write([]byte{cmdIAC, cmdWont, option})
 	return err
@@ -276,7 +357,7 @@ func (c *Telnet) subneg() error {
 				}
 			}
 		}
-		return c.sub(o, 0, 'X', 'T', 'E', 'R', 'M')
+		return c.sub(o, append([]byte{0}, c.nextTermType()...)...)
 	}
 
 	for {
@@ -352,14 +433,21 @@ func (c *Telnet) cmd(cmd byte) error {
 		}
 	case optWndSize: //optNAWS:
 		if cmd != cmdDo {
+			if cmd == cmdDont {
+				c.termMu.Lock()
+				c.naws = false
+				c.termMu.Unlock()
+			}
 			err = c.deny(cmd, o)
 			break
 		}
 		if err = c.will(o); err != nil {
 			break
 		}
-		// Reply with max window size: 65535x65535
-		err = c.sub(o, 0, 255, 0, 255)
+		c.termMu.Lock()
+		c.naws = true
+		err = c.writeWindowSizeLocked()
+		c.termMu.Unlock()
 
 	//case optWndSize:
 	//	if cmd == cmdDo {
@@ -402,6 +490,149 @@ func (c *Telnet) tryReadByte() (b byte, retry bool, err error) {
 	return
 }
 
+// SendSignal 发送 IAC IP, 只支持 SignalInterrupt.
+// 底层连接支持带外信号(如串口)时使用底层连接的
+func (c *Telnet) SendSignal(sig Signal) error {
+	if s, ok := c.nconn.(Signaler); ok {
+		return s.SendSignal(sig)
+	}
+	if sig != SignalInterrupt {
+		return ErrSignalUnsupported
+	}
+	_, err := c.w.Write([]byte{cmdIAC, cmdIP})
+	return err
+}
+
+// SendBreak 发送 IAC BRK, 底层连接支持 break(如串口)时使用底层连接的
+func (c *Telnet) SendBreak() error {
+	if s, ok := c.nconn.(Signaler); ok {
+		return s.SendBreak()
+	}
+	_, err := c.w.Write([]byte{cmdIAC, cmdBreak})
+	return err
+}
+
+// SetKeepalive 设置 keepalive, 必须在 TelnetWrap 之前调用, interval 为 0 时不发送,
+// 只有在 interval 内没有收到任何数据时才发送.
+// ayt 为 false 时发送 IAC NOP, 它没有回答, 只能发现写失败; 为 true 时发送 IAC AYT 并等待对端的任何数据,
+// 请注意对端对 AYT 的回答(如 "[Yes]")会出现在读到的数据中. 每次 keepalive 最多等待 interval,
+// 上一次还没有结果时不再发送新的. 连续 maxMissed 次没有响应时关闭连接, Expect 返回 *KeepaliveError
+func (c *Telnet) SetKeepalive(interval time.Duration, maxMissed int, ayt bool) {
+	c.keepaliveInterval = interval
+	c.keepaliveMaxMissed = maxMissed
+	c.keepaliveAYT = ayt
+}
+
+func (c *Telnet) ping(done <-chan struct{}) error {
+	if !c.keepaliveAYT {
+		_, err := c.w.Write([]byte{cmdIAC, cmdNOP})
+		return err
+	}
+
+	select {
+	case <-c.activity:
+	default:
+	}
+	if _, err := c.w.Write([]byte{cmdIAC, cmdAYT}); err != nil {
+		return err
+	}
+	select {
+	case <-c.activity:
+		return nil
+	case <-done:
+		return io.EOF
+	}
+}
+
+// DefaultTermType 为缺省的终端类型
+const DefaultTermType = "XTERM"
+
+// SetTermTypes 设置 TTYPE 协商时回答的终端类型, 必须在开始读数据之前调用.
+// 对端多次询问时按 RFC 1091 依次回答, 最后一个重复一次表示列表结束, 然后从头开始
+func (c *Telnet) SetTermTypes(types ...string) {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	c.termTypes = types
+	c.termTypeIdx = 0
+}
+
+func (c *Telnet) nextTermType() string {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	if len(c.termTypes) == 0 {
+		c.termType = DefaultTermType
+		return c.termType
+	}
+	idx := c.termTypeIdx
+	if idx >= len(c.termTypes) {
+		idx = len(c.termTypes) - 1
+	}
+	c.termTypeIdx++
+	if c.termTypeIdx > len(c.termTypes) {
+		c.termTypeIdx = 0
+	}
+	c.termType = c.termTypes[idx]
+	return c.termType
+}
+
+// Metadata 返回连接的元数据, 底层连接(如串口)能提供元数据时使用底层连接的
+func (c *Telnet) Metadata() *Metadata {
+	if provider, ok := c.nconn.(MetadataProvider); ok {
+		return provider.Metadata()
+	}
+
+	meta := &TelnetMetadata{}
+	c.termMu.Lock()
+	for opt := range c.localOpts {
+		if c.localOpts[opt] {
+			meta.LocalOptions = append(meta.LocalOptions, TelnetOptionName(byte(opt)))
+		}
+		if c.remoteOpts[opt] {
+			meta.RemoteOptions = append(meta.RemoteOptions, TelnetOptionName(byte(opt)))
+		}
+	}
+	meta.TermType = c.termType
+	if c.naws {
+		meta.Columns, meta.Rows = c.columns, c.rows
+	}
+	c.termMu.Unlock()
+
+	md := &Metadata{Transport: "telnet", Telnet: meta}
+	if c.nconn != nil {
+		md.RemoteAddr = addrString(c.nconn.RemoteAddr())
+		md.LocalAddr = addrString(c.nconn.LocalAddr())
+	}
+	return md
+}
+
+// Resize 改变窗口大小, 对端还没有同意 NAWS 时只记录下来, 在协商时发送
+func (c *Telnet) Resize(cols, rows int) error {
+	if cols <= 0 || cols > 0xffff || rows <= 0 || rows > 0xffff {
+		return errors.New("window size is invalid")
+	}
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	c.columns, c.rows = cols, rows
+	if !c.naws {
+		return nil
+	}
+	return c.writeWindowSizeLocked()
+}
+
+// writeWindowSizeLocked 发送 RFC 1073 中的 NAWS 子协商, 一次写入以免和用户数据交错
+func (c *Telnet) writeWindowSizeLocked() error {
+	bs := []byte{cmdIAC, cmdSB, optWndSize}
+	for _, b := range []byte{byte(c.columns >> 8), byte(c.columns), byte(c.rows >> 8), byte(c.rows)} {
+		if b == cmdIAC {
+			bs = append(bs, cmdIAC)
+		}
+		bs = append(bs, b)
+	}
+	bs = append(bs, cmdIAC, cmdSE)
+	_, err := c.w.Write(bs)
+	return err
+}
+
 // SetEcho tries to enable/disable echo on server side. Typically telnet
 // servers doesn't support this.
 func (c *Telnet) SetEcho(echo bool) error {
@@ -411,6 +642,23 @@ func (c *Telnet) SetEcho(echo bool) error {
 	return c.dont(optEcho)
 }
 
+// readBuffered 解码 bufio 中已缓存的数据, 最多 len(buf) 个字节, 不会为了等待新的数据而阻塞
+// (除非缓存的数据以一个不完整的命令结束)
+func (c *Telnet) readBuffered(buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) && c.r.Buffered() > 0 {
+		b, retry, err := c.tryReadByte()
+		if err != nil {
+			return n, err
+		}
+		if !retry {
+			buf[n] = b
+			n++
+		}
+	}
+	return n, nil
+}
+
 // ReadByte works like bufio.ReadByte
 func (c *Telnet) ReadByte() (b byte, err error) {
 	retry := true
@@ -8,10 +8,8 @@ import (
 	"testing"
 	"time"
 
-	// "github.com/mei-rune/shell/sim/sshd"
-	// "github.com/mei-rune/shell/sim/telnetd"
-	"tech.hengwei.com.cn/go/private/sim/sshd"
-	"tech.hengwei.com.cn/go/private/sim/telnetd"
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
 )
 
 func TestTelnetSimple(t *testing.T) {
@@ -492,3 +490,30 @@ func TestTelnetSimWithEnableWithYesNo(t *testing.T) {
 
 	testSimWithEnable(t, ctx, conn, prompt, "enable", "testsx")
 }
+
+func TestTelnetTermTypes(t *testing.T) {
+	send := []byte{cmdIAC, cmdSB, optWndType, 1, cmdIAC, cmdSE}
+	in := bytes.Repeat(send, 4)
+	in = append(in, 'a')
+
+	var out bytes.Buffer
+	c := NewTelnet2(nil, &out, bytes.NewReader(in))
+	c.SetTermTypes("VT100", "DUMB")
+	b, err := c.ReadByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != 'a' {
+		t.Errorf("want 'a' got %q", b)
+	}
+
+	var excepted []byte
+	for _, term := range []string{"VT100", "DUMB", "DUMB", "VT100"} {
+		excepted = append(excepted, cmdIAC, cmdSB, optWndType, 0)
+		excepted = append(excepted, term...)
+		excepted = append(excepted, cmdIAC, cmdSE)
+	}
+	if !bytes.Equal(out.Bytes(), excepted) {
+		t.Errorf("want %q got %q", excepted, out.Bytes())
+	}
+}
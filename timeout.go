@@ -0,0 +1,213 @@
+package shell
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/errors"
+)
+
+// TimeoutKind 表示是哪一种超时
+type TimeoutKind int
+
+const (
+	// IdleTimeout 为空闲超时, 即在 SetReadDeadline 设置的时间内没有收到任何数据
+	IdleTimeout TimeoutKind = iota
+	// ExpectTimeout 为单次 Expect 的超时, 由 SetExpectTimeout 设置
+	ExpectTimeout
+	// DeadlineTimeout 为整个操作的截止时间, 由 SetDeadline 设置
+	DeadlineTimeout
+)
+
+func (k TimeoutKind) String() string {
+	switch k {
+	case IdleTimeout:
+		return "idle timeout"
+	case ExpectTimeout:
+		return "expect timeout"
+	case DeadlineTimeout:
+		return "deadline timeout"
+	default:
+		return "timeout"
+	}
+}
+
+// TimeoutError 为超时错误, Kind 表示是哪一种超时.
+//
+// errors.Is(err, ErrTimeout) 对所有的超时都成立, ExpectTimeout 和 DeadlineTimeout
+// 还满足 errors.Is(err, context.DeadlineExceeded)
+type TimeoutError struct {
+	Kind     TimeoutKind
+	Duration time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Duration > 0 {
+		return e.Kind.String() + "(" + e.Duration.String() + ")"
+	}
+	return e.Kind.String()
+}
+
+// Timeout 实现 net.Error 中的 Timeout()
+func (e *TimeoutError) Timeout() bool { return true }
+
+// HTTPCode 和 ErrTimeout 相同, 这样 IsTimeout 可以识别它
+func (e *TimeoutError) HTTPCode() int { return ErrTimeout.HTTPCode() }
+
+func (e *TimeoutError) Is(target error) bool {
+	if target == ErrTimeout {
+		return true
+	}
+	return e.Kind != IdleTimeout && target == context.DeadlineExceeded
+}
+
+// IsTimeoutKind 判断 err 是不是指定种类的超时
+func IsTimeoutKind(err error, kind TimeoutKind) bool {
+	var te *TimeoutError
+	return errors.As(err, &te) && te.Kind == kind
+}
+
+// withTimeouts 返回在 expectTimeout 或 deadline(先到者)之后被取消的 ctx,
+// 超时后 context.Cause(ctx) 为对应的 *TimeoutError
+func withTimeouts(ctx context.Context, expectTimeout time.Duration, deadline time.Time) (context.Context, context.CancelFunc) {
+	var at time.Time
+	var cause *TimeoutError
+	if expectTimeout > 0 {
+		at = time.Now().Add(expectTimeout)
+		cause = &TimeoutError{Kind: ExpectTimeout, Duration: expectTimeout}
+	}
+	if !deadline.IsZero() && (at.IsZero() || deadline.Before(at)) {
+		at = deadline
+		cause = &TimeoutError{Kind: DeadlineTimeout}
+	}
+	if cause == nil {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	if !time.Now().Before(at) {
+		cancel(cause)
+		return ctx, func() {}
+	}
+	timer := time.AfterFunc(time.Until(at), func() {
+		cancel(cause)
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// expectContext 返回按 conn 的 ExpectTimeout 和 Deadline 设置了超时的 ctx, conn 不支持时原样返回
+func expectContext(ctx context.Context, conn Conn) (context.Context, context.CancelFunc) {
+	if c, ok := conn.(interface {
+		expectContext(ctx context.Context) (context.Context, context.CancelFunc)
+	}); ok {
+		return c.expectContext(ctx)
+	}
+	return ctx, func() {}
+}
+
+// ctxError 返回 ctx 被取消的原因, 超时时为 *TimeoutError
+func ctxError(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
+
+// TimeoutPolicy 决定 Expect 在空闲超时后怎么办, 返回 nil 时继续等待, 返回错误时 Expect 失败.
+// count 为本次 Expect 中第几次超时(从 1 开始), received 为本次已经收到的数据, err 为超时错误
+type TimeoutPolicy func(conn Conn, count int, received []byte, err error) error
+
+// DefaultTimeoutPolicy 为缺省的超时策略, 发送一个空格和换行, 原因请看 interactive.go 中的 FIXME: READ Timeout
+var DefaultTimeoutPolicy = TimeoutSendln([]byte(" "), 0)
+
+// TimeoutDoNothing 在超时后什么也不做, 直接返回超时错误
+var TimeoutDoNothing TimeoutPolicy = func(conn Conn, count int, received []byte, err error) error {
+	return err
+}
+
+// LimitTimeoutRetries 限制 policy 的重试次数, 超过 maxRetries 次后返回超时错误, maxRetries <= 0 时不限制
+func LimitTimeoutRetries(maxRetries int, policy TimeoutPolicy) TimeoutPolicy {
+	if maxRetries <= 0 {
+		return policy
+	}
+	return func(conn Conn, count int, received []byte, err error) error {
+		if count > maxRetries {
+			return err
+		}
+		return policy(conn, count, received, err)
+	}
+}
+
+// TimeoutSend 在超时后发送 bs, 最多重试 maxRetries 次
+func TimeoutSend(bs []byte, maxRetries int) TimeoutPolicy {
+	return LimitTimeoutRetries(maxRetries, func(conn Conn, count int, received []byte, err error) error {
+		return conn.Send(bs)
+	})
+}
+
+// TimeoutSendln 在超时后发送 bs 和换行, 最多重试 maxRetries 次
+func TimeoutSendln(bs []byte, maxRetries int) TimeoutPolicy {
+	return LimitTimeoutRetries(maxRetries, func(conn Conn, count int, received []byte, err error) error {
+		return conn.Sendln(bs)
+	})
+}
+
+// TimeoutSendNewline 在超时后发送一个换行
+func TimeoutSendNewline(maxRetries int) TimeoutPolicy {
+	return TimeoutSendln(nil, maxRetries)
+}
+
+// TimeoutSendSpace 在超时后发送一个空格, 用于翻页没有显示 more 提示的设备
+func TimeoutSendSpace(maxRetries int) TimeoutPolicy {
+	return TimeoutSend([]byte(" "), maxRetries)
+}
+
+// TimeoutSendCtrlC 在超时后发送 Ctrl-C, 用于中断卡住的命令
+func TimeoutSendCtrlC(maxRetries int) TimeoutPolicy {
+	return TimeoutSend([]byte{3}, maxRetries)
+}
+
+// ParseTimeoutPolicy 按名字返回超时策略, 名字为 none, newline, space, ctrl-c 或 space-newline
+func ParseTimeoutPolicy(name string, maxRetries int) (TimeoutPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "none":
+		return TimeoutDoNothing, nil
+	case "newline":
+		return TimeoutSendNewline(maxRetries), nil
+	case "space":
+		return TimeoutSendSpace(maxRetries), nil
+	case "ctrl-c", "ctrlc":
+		return TimeoutSendCtrlC(maxRetries), nil
+	case "space-newline":
+		return TimeoutSendln([]byte(" "), maxRetries), nil
+	default:
+		return nil, errors.New("timeout policy '" + name + "' is unknown")
+	}
+}
+
+var vendorTimeoutPolicies = struct {
+	sync.Mutex
+	policies map[string]TimeoutPolicy
+}{policies: map[string]TimeoutPolicy{
+	// 迪普设备偶尔不显示 -- more --, 见 interactive.go 中的 FIXME: READ Timeout
+	"dptech": TimeoutSendln([]byte(" "), 0),
+}}
+
+// RegisterVendorTimeoutPolicy 注册某个厂商设备的超时策略, 厂商名不区分大小写
+func RegisterVendorTimeoutPolicy(vendor string, policy TimeoutPolicy) {
+	vendorTimeoutPolicies.Lock()
+	defer vendorTimeoutPolicies.Unlock()
+	vendorTimeoutPolicies.policies[strings.ToLower(vendor)] = policy
+}
+
+// VendorTimeoutPolicy 返回某个厂商设备的超时策略, 没有注册时返回 nil
+func VendorTimeoutPolicy(vendor string) TimeoutPolicy {
+	vendorTimeoutPolicies.Lock()
+	defer vendorTimeoutPolicies.Unlock()
+	return vendorTimeoutPolicies.policies[strings.ToLower(vendor)]
+}
@@ -0,0 +1,153 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/errors"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	p := MakePipe(0)
+	p.Write([]byte("abc"))
+	defer p.Close()
+
+	conn := MakeConnWrapper(nil, ioutil.Discard, p)
+	conn.SetReadDeadline(50 * time.Millisecond)
+
+	_, _, err := conn.Expect([][]byte{[]byte(">")})
+	if !IsTimeoutKind(err, IdleTimeout) {
+		t.Errorf("want idle timeout got %v", err)
+	}
+	if !IsTimeout(err) || !errors.Is(err, ErrTimeout) {
+		t.Errorf("want timeout got %v", err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("idle timeout isnot context.DeadlineExceeded")
+	}
+}
+
+func TestExpectTimeout(t *testing.T) {
+	// 设备一直分页显示, 没有空闲的时候
+	p := MakePipe(0)
+	defer p.Close()
+	go func() {
+		for !p.IsClosed() {
+			p.Write([]byte("line\r\n<next page>"))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	conn := MakeConnWrapper(nil, ioutil.Discard, p)
+	conn.SetReadDeadline(1 * time.Second)
+	conn.SetExpectTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	err := Expect(context.Background(), &conn,
+		Match("<next page>", SaySpace),
+		Match("Router#", ReturnOK))
+	if !IsTimeoutKind(err, ExpectTimeout) {
+		t.Errorf("want expect timeout got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !IsTimeout(err) {
+		t.Errorf("want context.DeadlineExceeded got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1*time.Second {
+		t.Errorf("timeout is too slow, %s", elapsed)
+	}
+}
+
+func TestDeadlineTimeout(t *testing.T) {
+	p := MakePipe(0)
+	defer p.Close()
+
+	conn := MakeConnWrapper(nil, ioutil.Discard, p)
+	conn.SetReadDeadline(1 * time.Second)
+	conn.SetExpectTimeout(1 * time.Second)
+	conn.SetDeadline(time.Now().Add(100 * time.Millisecond))
+
+	start := time.Now()
+	_, err := ReadPrompt(context.Background(), &conn, [][]byte{[]byte(">")})
+	if !IsTimeoutKind(err, DeadlineTimeout) {
+		t.Errorf("want deadline timeout got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("timeout is too slow, %s", elapsed)
+	}
+
+	// 超过截止时间后, 后续的操作立即失败
+	_, _, err = conn.Expect([][]byte{[]byte(">")})
+	if !IsTimeoutKind(err, DeadlineTimeout) {
+		t.Errorf("want deadline timeout got %v", err)
+	}
+}
+
+func TestTimeoutPolicy(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		retries int
+		sent    string
+	}{
+		{name: "none", sent: ""},
+		{name: "newline", retries: 2, sent: "\n\n"},
+		{name: "space", retries: 1, sent: " "},
+		{name: "ctrl-c", retries: 3, sent: "\x03\x03\x03"},
+		{name: "space-newline", retries: 2, sent: " \n \n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := ParseTimeoutPolicy(test.name, test.retries)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			p := MakePipe(0)
+			defer p.Close()
+			var sent bytes.Buffer
+			conn := MakeConnWrapper(nil, &sent, p)
+			conn.SetReadDeadline(20 * time.Millisecond)
+			conn.SetTimeoutPolicy(policy)
+
+			_, err = ReadPrompt(context.Background(), &conn, [][]byte{[]byte(">")})
+			if !IsTimeoutKind(err, IdleTimeout) {
+				t.Errorf("want idle timeout got %v", err)
+			}
+			if sent.String() != test.sent {
+				t.Errorf("want %q got %q", test.sent, sent.String())
+			}
+		})
+	}
+
+	if _, err := ParseTimeoutPolicy("abc", 0); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestVendorTimeoutPolicy(t *testing.T) {
+	if VendorTimeoutPolicy("DPtech") == nil {
+		t.Error("want dptech policy got nil")
+	}
+
+	var count int
+	RegisterVendorTimeoutPolicy("TestVendor", func(conn Conn, n int, received []byte, err error) error {
+		count = n
+		if n >= 2 {
+			return err
+		}
+		return nil
+	})
+
+	p := MakePipe(0)
+	defer p.Close()
+	conn := MakeConnWrapper(nil, ioutil.Discard, p)
+	conn.SetReadDeadline(20 * time.Millisecond)
+	conn.SetTimeoutPolicy(VendorTimeoutPolicy("testvendor"))
+
+	_, err := ReadPrompt(context.Background(), &conn, [][]byte{[]byte(">")})
+	if !IsTimeoutKind(err, IdleTimeout) || count != 2 {
+		t.Errorf("want idle timeout after 2 calls got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,23 @@
+package shell
+
+import (
+	"github.com/runner-mei/errors"
+)
+
+const (
+	// DefaultColumns 和 DefaultRows 为 ssh 终端窗口的缺省大小
+	DefaultColumns = 1600
+	DefaultRows    = 800
+)
+
+var ErrResizeUnsupported = errors.New("resize is unsupported")
+
+// Resizer 为支持改变终端窗口大小的连接, 如 ssh 的 window-change 请求和 telnet 的 NAWS
+type Resizer interface {
+	Resize(cols, rows int) error
+}
+
+// Resize 发送 RFC 4254 中的 window-change 请求
+func (s sshSession) Resize(cols, rows int) error {
+	return s.session.WindowChange(rows, cols)
+}
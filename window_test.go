@@ -0,0 +1,150 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mei-rune/shell/sim"
+	"github.com/mei-rune/shell/sim/sshd"
+	"github.com/mei-rune/shell/sim/telnetd"
+	"golang.org/x/crypto/ssh"
+)
+
+// sttySize 模拟 stty size 命令, 输出 "rows cols"
+func sttySize(s *sim.Session, line, args []byte) error {
+	cols, rows := s.WindowSize()
+	return s.WriteString(strconv.Itoa(rows) + " " + strconv.Itoa(cols) + "\r\n")
+}
+
+// termType 模拟一个输出终端类型的命令
+func termType(s *sim.Session, line, args []byte) error {
+	return s.WriteString(s.Term() + "\r\n")
+}
+
+// readLine 执行命令并返回它输出的最后一行
+func readLine(t *testing.T, conn Conn, prompt []byte, cmd string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	cancel := conn.SetTeeOutput(&buf)
+	defer cancel()
+
+	if err := conn.Sendln([]byte(cmd)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPrompt(context.Background(), conn, [][]byte{prompt}); err != nil {
+		t.Fatal(err)
+	}
+	// 输出为 "stty\r\n24 80\r\nABC>", 取提示符前面的一行
+	lines := bytes.Split(buf.Bytes(), []byte("\r\n"))
+	if len(lines) < 2 {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	return string(lines[len(lines)-2])
+}
+
+func testResize(t *testing.T, conn Conn, prompt []byte, initial string) {
+	if size := readLine(t, conn, prompt, "stty"); size != initial {
+		t.Errorf("want %q got %q", initial, size)
+	}
+
+	if err := conn.Resize(132, 50); err != nil {
+		t.Fatal(err)
+	}
+	// window-change 和 NAWS 都是异步的, 等一会再读
+	time.Sleep(100 * time.Millisecond)
+	if size := readLine(t, conn, prompt, "stty"); size != "50 132" {
+		t.Errorf("want %q got %q", "50 132", size)
+	}
+}
+
+func TestTelnetResize(t *testing.T) {
+	options := &telnetd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", telnetd.OS(telnetd.Commands{
+		"stty": sttySize,
+		"term": termType,
+	}))
+
+	listener, err := telnetd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	telnetConn, err := DialTelnetTimeout("tcp", net.JoinHostPort("127.0.0.1", listener.Port()), 1*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 在协商之前设置初始大小, 包含需要转义的 255
+	if err := telnetConn.Resize(255, 24); err != nil {
+		t.Fatal(err)
+	}
+	telnetConn.SetTermTypes("VT100", "DUMB")
+	conn := TelnetWrap(telnetConn, nil, nil)
+	defer conn.Close()
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	prompt, err := UserLogin(context.Background(), conn, nil, []byte("abc"), nil, []byte("123"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResize(t, conn, prompt, "24 255")
+
+	// telnetd 只询问一次
+	if term := readLine(t, conn, prompt, "term"); term != "VT100" {
+		t.Errorf("want VT100 got %q", term)
+	}
+}
+
+func TestSSHResize(t *testing.T) {
+	options := &sshd.Options{}
+	options.AddUserPassword("abc", "123")
+	options.WithNoEnable("ABC>", sshd.OS(sshd.Commands{
+		"stty": sttySize,
+		"term": termType,
+	}))
+
+	listener, err := sshd.StartServer(":", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := ConnectSSHWithOptions(net.JoinHostPort("127.0.0.1", listener.Port()), "abc", "123", "", &SSHOptions{
+		Columns: 80,
+		Rows:    24,
+		Term:    "vt100",
+		Modes:   ssh.TerminalModes{ssh.ECHO: 1},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.UseCRLF()
+	conn.SetReadDeadline(1 * time.Second)
+
+	prompt, err := ReadPrompt(context.Background(), conn, [][]byte{[]byte(">")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResize(t, conn, prompt, "24 80")
+
+	if term := readLine(t, conn, prompt, "term"); term != "vt100" {
+		t.Errorf("want vt100 got %q", term)
+	}
+}
+
+func TestResizeUnsupported(t *testing.T) {
+	p := MakePipe(0)
+	defer p.Close()
+
+	conn := MakeConnWrapper(nil, &bytes.Buffer{}, p)
+	if err := conn.Resize(80, 24); err != ErrResizeUnsupported {
+		t.Errorf("want ErrResizeUnsupported got %v", err)
+	}
+}